@@ -0,0 +1,149 @@
+package crawl
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/gocolly/colly"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Politeness controls how hard a crawler hits the sites it visits.
+// The zero value sends requests as fast as the crawler's default parallelism allows and never retries.
+type Politeness struct {
+	// DomainGlob selects the domains the limits apply to, "*" if empty.
+	// All matching domains share the limits.
+	DomainGlob string
+	// Delay is waited after each request, plus a random duration up to RandomDelay.
+	Delay       time.Duration
+	RandomDelay time.Duration
+	// Parallelism is the max number of requests running at once, the crawler's default if 0.
+	Parallelism int
+	// UserAgent is sent with every request, colly's default if empty.
+	UserAgent        string
+	RespectRobotsTxt bool
+	// MaxRetries is how often a request failing with 429, a 5xx status or a network error is retried.
+	MaxRetries int
+	// The n-th retry waits BackoffBase * 2^(n-1), at most BackoffMax,
+	// or longer if the response has a Retry-After header.
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+}
+
+// DefaultPoliteness is used by the command line.
+var DefaultPoliteness = Politeness{
+	Delay:       500 * time.Millisecond,
+	RandomDelay: 500 * time.Millisecond,
+	MaxRetries:  3,
+	BackoffBase: time.Second,
+	BackoffMax:  time.Minute,
+}
+
+// Apply sets the limits, user agent and robots.txt handling of p on c, and retries its failed requests.
+// defaultParallelism is used when p.Parallelism is 0.
+// Clones of c share its limits, user agent and robots.txt handling, call HandleRetries to retry theirs.
+func (p Politeness) Apply(c *colly.Collector, defaultParallelism int, report *Report) error {
+	if p.UserAgent != "" {
+		c.UserAgent = p.UserAgent
+	}
+	c.IgnoreRobotsTxt = !p.RespectRobotsTxt
+	p.HandleRetries(c, report)
+	glob := p.DomainGlob
+	if glob == "" {
+		glob = "*"
+	}
+	parallelism := p.Parallelism
+	if parallelism == 0 {
+		parallelism = defaultParallelism
+	}
+	return c.Limit(&colly.LimitRule{
+		DomainGlob:  glob,
+		Delay:       p.Delay,
+		RandomDelay: p.RandomDelay,
+		Parallelism: parallelism,
+	})
+}
+
+// HandleRetries retries the requests of c failing with 429, a 5xx status or a network error,
+// up to p.MaxRetries times per URL. Every retry is counted in report, which may be nil.
+func (p Politeness) HandleRetries(c *colly.Collector, report *Report) {
+	if p.MaxRetries <= 0 {
+		return
+	}
+	c.OnError(func(response *colly.Response, err error) {
+		status := response.StatusCode
+		if status != 0 && status != http.StatusTooManyRequests && status < 500 {
+			return
+		}
+		// the context may be shared with other requests, count per URL
+		key := "retries " + response.Request.URL.String()
+		retries, _ := response.Ctx.GetAny(key).(int)
+		if retries >= p.MaxRetries {
+			return
+		}
+		retries++
+		response.Ctx.Put(key, retries)
+		var header http.Header
+		if response.Headers != nil {
+			header = *response.Headers
+		}
+		wait := p.Backoff(retries, header)
+		if report != nil {
+			report.Retry()
+		}
+		time.Sleep(wait)
+		// colly caches responses below 500, a cached 429 would be returned again
+		if status == http.StatusTooManyRequests && c.CacheDir != "" {
+			os.Remove(cacheFile(c.CacheDir, response.Request.URL.String()))
+		}
+		if err := response.Request.Retry(); err != nil && report != nil {
+			report.Error(fmt.Errorf("retry %s: %w", response.Request.URL, err))
+		}
+	})
+}
+
+// Backoff returns how long to wait before the given retry, starting at 1.
+// A Retry-After header of header, in seconds or as an HTTP date, is honored when it asks for longer.
+func (p Politeness) Backoff(retry int, header http.Header) time.Duration {
+	base := p.BackoffBase
+	if base <= 0 {
+		base = time.Second
+	}
+	wait := base
+	for i := 1; i < retry && (p.BackoffMax <= 0 || wait < p.BackoffMax); i++ {
+		wait *= 2
+	}
+	if p.BackoffMax > 0 && wait > p.BackoffMax {
+		wait = p.BackoffMax
+	}
+	if after := retryAfter(header); after > wait {
+		wait = after
+	}
+	return wait
+}
+
+// retryAfter parses the Retry-After header, 0 if missing or invalid.
+func retryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// cacheFile returns the file colly caches the response of url in.
+func cacheFile(cacheDir string, url string) string {
+	sum := sha1.Sum([]byte(url))
+	hash := hex.EncodeToString(sum[:])
+	return filepath.Join(cacheDir, hash[:2], hash)
+}
@@ -0,0 +1,134 @@
+package crawl
+
+import (
+	"github.com/gocolly/colly"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer answers with the statuses of responses in turn, then with 200, and records when it was requested.
+type flakyServer struct {
+	mu        sync.Mutex
+	responses []int
+	header    http.Header // sent with the failed responses
+	times     []time.Time
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.times)
+	s.times = append(s.times, time.Now())
+	if n < len(s.responses) {
+		for key, values := range s.header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(s.responses[n])
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// visit requests the server with a collector retrying as p says, and returns the number of successful responses.
+// Retries run inside the OnError callback of the failed request, so the order of the callbacks does not tell which came last.
+func (s *flakyServer) visit(t *testing.T, p Politeness, cacheDir string) (int, *Report) {
+	t.Helper()
+	server := httptest.NewServer(s)
+	defer server.Close()
+	c := colly.NewCollector()
+	c.CacheDir = cacheDir
+	report := NewReport("test")
+	p.HandleRetries(c, report)
+	succeeded := 0
+	c.OnResponse(func(response *colly.Response) {
+		succeeded++
+	})
+	c.Visit(server.URL + "/page")
+	return succeeded, report
+}
+
+func TestRetryAfter(t *testing.T) {
+	s := &flakyServer{responses: []int{http.StatusTooManyRequests}, header: http.Header{"Retry-After": {"1"}}}
+	succeeded, report := s.visit(t, Politeness{MaxRetries: 3, BackoffBase: 10 * time.Millisecond}, "")
+	if succeeded != 1 {
+		t.Fatalf("got %d successful responses, want 1", succeeded)
+	}
+	if len(s.times) != 2 || report.Retries != 1 {
+		t.Fatalf("got %d requests and %d retries, want 2 and 1", len(s.times), report.Retries)
+	}
+	if wait := s.times[1].Sub(s.times[0]); wait < time.Second {
+		t.Errorf("retried after %s, want the Retry-After of 1s", wait)
+	}
+}
+
+func TestRetryServerErrorBackoff(t *testing.T) {
+	s := &flakyServer{responses: []int{http.StatusInternalServerError, http.StatusServiceUnavailable}}
+	base := 50 * time.Millisecond
+	succeeded, report := s.visit(t, Politeness{MaxRetries: 3, BackoffBase: base}, "")
+	if succeeded != 1 {
+		t.Fatalf("got %d successful responses, want 1", succeeded)
+	}
+	if len(s.times) != 3 || report.Retries != 2 {
+		t.Fatalf("got %d requests and %d retries, want 3 and 2", len(s.times), report.Retries)
+	}
+	for i, want := range []time.Duration{base, 2 * base} {
+		if wait := s.times[i+1].Sub(s.times[i]); wait < want {
+			t.Errorf("retry %d after %s, want at least %s", i+1, wait, want)
+		}
+	}
+}
+
+func TestRetryStopsAtMaxRetries(t *testing.T) {
+	s := &flakyServer{responses: []int{500, 500, 500, 500, 500}}
+	succeeded, report := s.visit(t, Politeness{MaxRetries: 2, BackoffBase: time.Millisecond}, "")
+	if succeeded != 0 {
+		t.Errorf("got %d successful responses, want none", succeeded)
+	}
+	if len(s.times) != 3 || report.Retries != 2 {
+		t.Errorf("got %d requests and %d retries, want 3 and 2", len(s.times), report.Retries)
+	}
+}
+
+func TestRetryNotFoundIsNotRetried(t *testing.T) {
+	s := &flakyServer{responses: []int{http.StatusNotFound}}
+	succeeded, report := s.visit(t, Politeness{MaxRetries: 2, BackoffBase: time.Millisecond}, "")
+	if succeeded != 0 || len(s.times) != 1 || report.Retries != 0 {
+		t.Errorf("got %d successful responses after %d requests and %d retries, want none after 1 request", succeeded, len(s.times), report.Retries)
+	}
+}
+
+func TestRetryDoesNotReplayCached429(t *testing.T) {
+	s := &flakyServer{responses: []int{http.StatusTooManyRequests}}
+	succeeded, _ := s.visit(t, Politeness{MaxRetries: 2, BackoffBase: time.Millisecond}, t.TempDir())
+	if succeeded != 1 {
+		t.Errorf("got %d successful responses, want 1", succeeded)
+	}
+	if len(s.times) != 2 {
+		t.Errorf("the server got %d requests, want 2, the retry must not be answered from the cache", len(s.times))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := Politeness{BackoffBase: time.Second, BackoffMax: 5 * time.Second}
+	tests := []struct {
+		retry  int
+		header http.Header
+		want   time.Duration
+	}{
+		{1, nil, time.Second},
+		{2, nil, 2 * time.Second},
+		{3, nil, 4 * time.Second},
+		{4, nil, 5 * time.Second},
+		{1, http.Header{"Retry-After": {"30"}}, 30 * time.Second},
+		{3, http.Header{"Retry-After": {"2"}}, 4 * time.Second},
+		{1, http.Header{"Retry-After": {"soon"}}, time.Second},
+	}
+	for _, test := range tests {
+		if got := p.Backoff(test.retry, test.header); got != test.want {
+			t.Errorf("Backoff(%d, %v) = %s, want %s", test.retry, test.header, got, test.want)
+		}
+	}
+}
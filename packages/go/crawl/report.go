@@ -0,0 +1,133 @@
+// Package crawl holds what the crawlers of this module share: run reports, politeness settings
+// and the persistent state of resumable crawls.
+package crawl
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gocolly/colly"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxErrors is the number of error messages kept in a Report, later errors are only counted.
+const maxErrors = 100
+
+// Report is the machine-readable summary of a crawl run, safe for concurrent use.
+type Report struct {
+	mu       sync.Mutex
+	Name     string
+	Started  time.Time
+	Finished time.Time
+	// Requests is the number of requests started, PagesVisited the number of responses received.
+	Requests     int
+	PagesVisited int
+	// Items counts what the crawl produced, e.g. "books" or "images".
+	Items           map[string]int
+	StatusHistogram map[int]int // responses and errors by HTTP status, 0 for network errors
+	Retries         int
+	// SelectorChecks counts how often each CSS selector was looked up,
+	// EmptySelectors how often it came back empty.
+	SelectorChecks map[string]int
+	EmptySelectors map[string]int
+	ErrorCount     int
+	Errors         []string // the first maxErrors error messages
+	// Extra holds crawler specific details, e.g. search result counts.
+	Extra map[string]interface{}
+}
+
+// NewReport returns a Report of a run starting now.
+func NewReport(name string) *Report {
+	return &Report{
+		Name:            name,
+		Started:         time.Now(),
+		Items:           make(map[string]int),
+		StatusHistogram: make(map[int]int),
+		SelectorChecks:  make(map[string]int),
+		EmptySelectors:  make(map[string]int),
+		Extra:           make(map[string]interface{}),
+	}
+}
+
+// Track counts the requests, responses and errors of c.
+func (r *Report) Track(c *colly.Collector) {
+	c.OnRequest(func(_ *colly.Request) {
+		r.mu.Lock()
+		r.Requests++
+		r.mu.Unlock()
+	})
+	c.OnResponse(func(response *colly.Response) {
+		r.mu.Lock()
+		r.PagesVisited++
+		r.StatusHistogram[response.StatusCode]++
+		r.mu.Unlock()
+	})
+	c.OnError(func(response *colly.Response, err error) {
+		status := 0
+		if response != nil {
+			status = response.StatusCode
+		}
+		r.mu.Lock()
+		r.StatusHistogram[status]++
+		r.mu.Unlock()
+		url := ""
+		if response != nil && response.Request != nil {
+			url = response.Request.URL.String()
+		}
+		r.Error(fmt.Errorf("%s: %w", url, err))
+	})
+}
+
+// Count adds n to the count of item.
+func (r *Report) Count(item string, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Items[item] += n
+}
+
+// Selector records a lookup of selector, and whether it came back empty.
+func (r *Report) Selector(selector string, empty bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.SelectorChecks[selector]++
+	if empty {
+		r.EmptySelectors[selector]++
+	}
+}
+
+// Retry records a retried request.
+func (r *Report) Retry() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Retries++
+}
+
+// Error records an error.
+func (r *Report) Error(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ErrorCount++
+	if len(r.Errors) < maxErrors {
+		r.Errors = append(r.Errors, err.Error())
+	}
+}
+
+// Set sets a crawler specific detail.
+func (r *Report) Set(key string, value interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Extra[key] = value
+}
+
+// WriteFile marks the run as finished and writes the report as indented JSON to filename.
+func (r *Report) WriteFile(filename string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Finished = time.Now()
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, b, 0644)
+}
@@ -0,0 +1,274 @@
+package crawl
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/gocolly/colly"
+	"hash/fnv"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// State is the persistent state of a crawl: the visited set and cookies of colly,
+// the frontier of requested URLs and the completed items, e.g. the HNs of emitted books.
+// It is kept in memory and every change is appended to a log file, so an interrupted crawl can be resumed.
+// State implements colly's storage.Storage, set it with SetStorage before cloning the collectors.
+type State struct {
+	mu        sync.Mutex
+	file      *os.File
+	enc       *json.Encoder
+	visited   map[uint64]bool
+	cookies   map[string]string
+	frontier  map[string]*FrontierEntry
+	order     []string // URLs of the frontier in request order
+	completed map[string]bool
+}
+
+// FrontierEntry is a URL requested by a collector that has not been scraped yet.
+type FrontierEntry struct {
+	URL       string
+	Collector string            // name the collector was tracked with
+	Ctx       map[string]string // string values of the request context
+}
+
+// stateRecord is one line of the state log.
+type stateRecord struct {
+	Op        string            // "visited", "unvisited", "cookies", "queued", "done" or "completed"
+	ID        uint64            `json:",omitempty"`
+	URL       string            `json:",omitempty"`
+	Collector string            `json:",omitempty"`
+	Ctx       map[string]string `json:",omitempty"`
+	Value     string            `json:",omitempty"`
+}
+
+// OpenState opens the state log filename. With resume, the state of the previous run is loaded
+// and the log compacted, otherwise the crawl starts over with an empty state.
+func OpenState(filename string, resume bool) (*State, error) {
+	s := &State{
+		visited:   make(map[uint64]bool),
+		cookies:   make(map[string]string),
+		frontier:  make(map[string]*FrontierEntry),
+		completed: make(map[string]bool),
+	}
+	if resume {
+		if err := s.load(filename); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+	// write the loaded state to a new log, dropping what was undone
+	tmp := filename + "~"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	s.file = file
+	s.enc = json.NewEncoder(file)
+	for id := range s.visited {
+		s.append(stateRecord{Op: "visited", ID: id})
+	}
+	for host, cookies := range s.cookies {
+		s.append(stateRecord{Op: "cookies", URL: host, Value: cookies})
+	}
+	for _, u := range s.order {
+		e := s.frontier[u]
+		s.append(stateRecord{Op: "queued", URL: e.URL, Collector: e.Collector, Ctx: e.Ctx})
+	}
+	for item := range s.completed {
+		s.append(stateRecord{Op: "completed", Value: item})
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load replays the state log filename, which may not exist yet.
+// A last line cut off by an interruption is ignored.
+func (s *State) load(filename string) error {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var r stateRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		s.apply(r)
+	}
+	return scanner.Err()
+}
+
+// apply changes the in-memory state by r.
+func (s *State) apply(r stateRecord) {
+	switch r.Op {
+	case "visited":
+		s.visited[r.ID] = true
+	case "unvisited":
+		delete(s.visited, r.ID)
+	case "cookies":
+		s.cookies[r.URL] = r.Value
+	case "queued":
+		if _, ok := s.frontier[r.URL]; !ok {
+			s.order = append(s.order, r.URL)
+		}
+		s.frontier[r.URL] = &FrontierEntry{r.URL, r.Collector, r.Ctx}
+	case "done":
+		if _, ok := s.frontier[r.URL]; ok {
+			delete(s.frontier, r.URL)
+			for i, u := range s.order {
+				if u == r.URL {
+					s.order = append(s.order[:i], s.order[i+1:]...)
+					break
+				}
+			}
+		}
+	case "completed":
+		s.completed[r.Value] = true
+	}
+}
+
+// append writes r to the log. Errors are ignored, at worst the record is redone on resume.
+func (s *State) append(r stateRecord) {
+	if s.enc != nil {
+		s.enc.Encode(r)
+	}
+}
+
+// record applies r and writes it to the log.
+func (s *State) record(r stateRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apply(r)
+	s.append(r)
+}
+
+// Init implements storage.Storage.
+func (s *State) Init() error {
+	return nil
+}
+
+// Visited implements storage.Storage.
+func (s *State) Visited(requestID uint64) error {
+	s.record(stateRecord{Op: "visited", ID: requestID})
+	return nil
+}
+
+// IsVisited implements storage.Storage.
+func (s *State) IsVisited(requestID uint64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.visited[requestID], nil
+}
+
+// Cookies implements storage.Storage.
+func (s *State) Cookies(u *url.URL) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cookies[u.Host]
+}
+
+// SetCookies implements storage.Storage.
+func (s *State) SetCookies(u *url.URL, cookies string) {
+	s.record(stateRecord{Op: "cookies", URL: u.Host, Value: cookies})
+}
+
+// Track adds the URLs requested by c to the frontier under the given collector name,
+// and removes them once scraped. Requests that fail stay in the frontier and are retried on resume.
+func (s *State) Track(c *colly.Collector, name string) {
+	c.OnRequest(func(r *colly.Request) {
+		ctx := make(map[string]string)
+		r.Ctx.ForEach(func(k string, v interface{}) interface{} {
+			if value, ok := v.(string); ok {
+				ctx[k] = value
+			}
+			return nil
+		})
+		s.record(stateRecord{Op: "queued", URL: r.URL.String(), Collector: name, Ctx: ctx})
+	})
+	c.OnScraped(func(response *colly.Response) {
+		s.record(stateRecord{Op: "done", URL: response.Request.URL.String()})
+	})
+}
+
+// Requeue puts a scraped URL back in the frontier of the collector tracked as name, without context,
+// so it is requested again on resume, e.g. a page whose items could not all be saved.
+func (s *State) Requeue(u string, name string) {
+	s.record(stateRecord{Op: "queued", URL: u, Collector: name})
+}
+
+// Pending returns the frontier, in the order the URLs were requested.
+func (s *State) Pending() []FrontierEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := make([]FrontierEntry, len(s.order))
+	for i, u := range s.order {
+		pending[i] = *s.frontier[u]
+	}
+	return pending
+}
+
+// Resume requests the frontier of the previous run again. Every pending URL is marked unvisited,
+// so the page that found it may find it again, but only the URLs of the given collectors,
+// keyed by the names they were tracked with, are requested with their context.
+// The errors of colly.ErrAlreadyVisited, of URLs found again by an earlier page, are not returned.
+func (s *State) Resume(collectors map[string]*colly.Collector) []error {
+	pending := s.Pending()
+	for _, e := range pending {
+		s.record(stateRecord{Op: "unvisited", ID: requestID(e.URL)})
+	}
+	var errs []error
+	for _, e := range pending {
+		c, ok := collectors[e.Collector]
+		if !ok {
+			continue
+		}
+		ctx := colly.NewContext()
+		for k, v := range e.Ctx {
+			ctx.Put(k, v)
+		}
+		if err := c.Request("GET", e.URL, nil, ctx, nil); err != nil && err != colly.ErrAlreadyVisited {
+			errs = append(errs, fmt.Errorf("%s: %w", e.URL, err))
+		}
+	}
+	return errs
+}
+
+// Complete marks item as completed, e.g. a book that was emitted.
+func (s *State) Complete(item string) {
+	s.record(stateRecord{Op: "completed", Value: item})
+}
+
+// IsComplete tells whether item was completed, in this run or before it was resumed.
+func (s *State) IsComplete(item string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.completed[item]
+}
+
+// Close closes the state log.
+func (s *State) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enc = nil
+	return s.file.Close()
+}
+
+// requestID returns the ID colly stores in the visited set for a GET request of u.
+func requestID(u string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(u))
+	return h.Sum64()
+}
@@ -3,7 +3,7 @@ module github.com/bluemonarch21/matchmaker
 go 1.16
 
 require (
-	github.com/PuerkitoBio/goquery v1.6.1 // indirect
+	github.com/PuerkitoBio/goquery v1.6.1
 	github.com/antchfx/htmlquery v1.2.3 // indirect
 	github.com/antchfx/xmlquery v1.3.6 // indirect
 	github.com/aws/aws-sdk-go v1.38.25 // indirect
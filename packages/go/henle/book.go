@@ -1,24 +1,21 @@
 package henle
 
 import (
-	"context"
-	"encoding/csv"
-	"encoding/json"
+	"bytes"
 	"fmt"
+	"github.com/bluemonarch21/matchmaker/crawl"
 	"github.com/gocolly/colly"
-	"go.mongodb.org/mongo-driver/mongo"
 	"io"
 	"log"
 	"os"
-	"strconv"
-	"strings"
-	"sync"
 )
 
 type Book struct {
 	URL             string
 	Title           string
 	Composer        string
+	ComposerURL     string // profile page of the composer, "" if not linked
+	ComposerID      string // see PersonID
 	Authors         []Contributor
 	Price           string
 	Instrumentation string
@@ -26,99 +23,100 @@ type Book struct {
 	HN              int
 	ISMN            string
 	Description     string
-	Details         []Detail
+	Sections        []Section // contents of the book in page order
 	CoverLink       string
+	Cover           *CoverImage             // downloaded cover, nil unless CrawlOptions.Covers is set
+	Pageflip        *PageflipInfo           // payload of the preview viewer, nil if the page does not embed it
+	Localized       map[string]Localization // texts per language, only set when other languages are scraped
+	Missing         []string                // fields not found on the page
+	FieldErrors     []FieldError            // fields found on the page but not parsed
+}
+
+// FieldError records why a field of a book could not be parsed.
+type FieldError struct {
+	Field string
+	Error string
+}
+
+// Partial reports whether some fields of the book could not be extracted.
+func (b Book) Partial() bool {
+	return len(b.Missing) > 0 || len(b.FieldErrors) > 0
 }
 
 type Contributor struct {
-	Name string
-	Role string
-	URL  string
+	Name     string
+	Role     string
+	URL      string // profile page, "" if the contributor has none
+	PersonID string // see PersonID, "" if the contributor has no profile page
 }
 
+// Section is a heading of the contents of a book with the pieces listed under it.
+// Pieces listed before the first heading are in a Section without Title.
+type Section struct {
+	Title  string
+	Pieces []Detail
+}
+
+// Detail is a piece, or a movement of a piece, listed in the contents of a book.
 type Detail struct {
 	Title           string
-	HenleDifficulty string
-	ABRSMDifficulty []string
-	Section         string
+	HenleDifficulty string   // raw text, e.g. "Piano 5" or "Violin 6 Piano 5"
+	ABRSMDifficulty []string // raw link texts
+	Section         string   // title of the section, "" if none
 	Composer        string
+	Difficulties    []Difficulty // one per part, e.g. violin and piano, in page order
+	ABRSMGrades     []ABRSMGrade
+	Work            WorkInfo // parsed from Title, see ParseWorkTitle
+	Movements       []Detail
 }
 
-// writeToCsv writes to CSV whenever new book is added to the books chan
-func writeToCsv(books *chan Book, done *chan bool, file *os.File) {
-	writer := csv.NewWriter(file)
-	for {
-		book, ok := <-*books
-		if !ok {
-			fmt.Println("books closed!")
-			*done <- true
-			return
-		}
-		//fmt.Println("Got a book?")
-		//fmt.Println(book)
-		for _, detail := range book.Details {
-			row := []string{
-				detail.Section,
-				detail.Title,
-				detail.Composer,
-				fmt.Sprint(detail.HenleDifficulty),
-				strings.Join(detail.ABRSMDifficulty, "|"),
-				book.URL,
-				book.Title,
-				book.Composer,
-				book.Price,
-				book.Instrumentation,
-				book.BookInfo,
-				fmt.Sprint(book.HN),
-				book.ISMN,
-				book.Description,
-				book.CoverLink,
-			}
-			for _, author := range book.Authors {
-				row = append(row, author.Name, author.Role, author.URL)
-			}
-			//fmt.Println("writing this row:", row)
-			err := writer.Write(row)
-			if err != nil {
-				fmt.Println(err)
-			}
-			writer.Flush()
-		}
-	}
+// Details flattens the contents of the book: the pieces of every section, each followed by its movements,
+// in page order. The returned details have no Movements.
+func (b Book) Details() []Detail {
+	var details []Detail
+	b.walkDetails(func(detail Detail, _ *Detail) {
+		detail.Movements = nil
+		details = append(details, detail)
+	})
+	return details
 }
 
-// writeToMongo consumes a channel of books, writes the books into a file in JSONL format, and sends true when done to a bool channel.
-func writeToJson(books *chan Book, done *chan bool, file *os.File) {
-	enc := json.NewEncoder(file)
-	enc.SetIndent("", "  ")
-	for {
-		book, ok := <-*books
-		if !ok {
-			*done <- true
-			return
+// walkDetails calls fn for every piece and movement of the book in page order,
+// with the piece a movement belongs to as parent, nil for pieces.
+func (b Book) walkDetails(fn func(detail Detail, parent *Detail)) {
+	var walk func(details []Detail, parent *Detail)
+	walk = func(details []Detail, parent *Detail) {
+		for i := range details {
+			fn(details[i], parent)
+			walk(details[i].Movements, &details[i])
 		}
-		enc.Encode(book)
 	}
-}
-
-// writeToMongo consumes a channel of books, inserts the books into a mongodb collection, and sends true when done to a bool channel.
-func writeToMongo(books *chan Book, done *chan bool, collection *mongo.Collection) {
-	for {
-		book, ok := <-*books
-		if !ok {
-			*done <- true
-			return
-		}
-		res, err := collection.InsertOne(context.Background(), book)
-		if err != nil {
-			log.Println(err)
-			log.Println("error inserting ", book)
-		}
-		fmt.Println("inserted ", res.InsertedID)
+	for _, section := range b.Sections {
+		walk(section.Pieces, nil)
 	}
 }
 
-func setupBookDetailCollectors(c *colly.Collector, c2 *colly.Collector, books *chan Book, stdout io.Writer) {
+func setupBookDetailCollectors(c *colly.Collector, c2 *colly.Collector, sink BookSink, opts CrawlOptions, report *crawl.Report, stdout io.Writer) {
+	seen := newHNSet()    // HNs of the search results
+	written := newHNSet() // HNs of the parsed books, also of results whose HN is not in the search list
+	c3 := c2.Clone()
+	setupLocalizedCollector(c3, stdout)
+	opts.Politeness.HandleRetries(c3, report)
+	// covers are requested conditionally on every crawl, possibly from another host, and not cached
+	c4 := c2.Clone()
+	c4.AllowedDomains = nil
+	c4.CacheDir = ""
+	c4.AllowURLRevisit = true
+	setupCoverCollector(c4, stdout)
+	opts.Politeness.HandleRetries(c4, report)
+	opts.track(c, "search")
+	opts.track(c2, "details")
+	opts.track(c3, "localized")
+	report.Track(c)
+	report.Track(c2)
+	report.Track(c3)
+	report.Track(c4)
+
 	// Before making a request print "Visiting ..."
 	c.OnRequest(func(r *colly.Request) {
 		fmt.Fprintln(stdout, "c Visiting", r.URL.String())
@@ -135,171 +133,80 @@ func setupBookDetailCollectors(c *colly.Collector, c2 *colly.Collector, books *c
 	})
 
 	// Let detail collector visit pages linked on book cover from a search page
-	c.OnHTML("article.result-item > div.result-column-left > figure.result-cover > a", func(e *colly.HTMLElement) {
-		link := e.Attr("href")
-		fmt.Fprintf(stdout, "Link found: %s\n", link)
-		err := c2.Visit(e.Request.AbsoluteURL(link))
+	c.OnResponse(func(response *colly.Response) {
+		results, err := ParseSearchResults(bytes.NewReader(response.Body), response.Request.URL)
 		if err != nil {
-			fmt.Fprintf(stdout, "c2.Visiting %s error: %s", e.Request.AbsoluteURL(link), err)
+			fmt.Fprintf(stdout, "ParseSearchResults %s error: %s\n", response.Request.URL, err)
+			report.Error(err)
+			return
+		}
+		report.Selector("article.result-item", len(results) == 0)
+		for _, result := range results {
+			if result.URL == "" {
+				continue
+			}
+			if result.HN != 0 && !seen.add(result.HN) {
+				fmt.Fprintf(stdout, "Skipping HN %04d found by more than one query\n", result.HN)
+				continue
+			}
+			if opts.completed(result.HN) {
+				fmt.Fprintf(stdout, "Skipping HN %04d completed before\n", result.HN)
+				continue
+			}
+			fmt.Fprintf(stdout, "Link found: %s\n", result.URL)
+			if err := c2.Visit(result.URL); err != nil {
+				fmt.Fprintf(stdout, "c2.Visiting %s error: %s", result.URL, err)
+			}
 		}
 	})
 
 	// Extract details of the book
-	c2.OnHTML(":root", func(e *colly.HTMLElement) {
-		title := make(chan string, 1)
-		composer := make(chan string, 1)
-		price := make(chan string, 1)
-		description := make(chan string, 1)
-		coverLink := make(chan string, 1)
-		instrumentation := make(chan string, 1)
-		authors := make(chan []Contributor, 1)
-		bookInfo := make(chan string, 1)
-		hn := make(chan int, 1)
-		ismn := make(chan string, 1)
-		// collect 'Book' information
-		e.ForEachWithBreak("div.detail-hero", func(i int, e *colly.HTMLElement) bool {
-			go func() { title <- e.ChildText("h2.main-title") }()
-			go func() { composer <- e.ChildText("h2.sub-title") }()
-			go func() {
-				price <- strings.Replace(e.DOM.Find("div.column-cart > p.price").Contents().Not("br,span").Text(), " ", " ", 1)
-			}()
-			go func() { description <- strings.Replace(e.ChildText("div.article-text"), "\n", "\\n", -1) }()
-			go func() {
-				coverLink <- e.Request.AbsoluteURL(e.ChildAttr("figure.cover-container > a > img", "data-src"))
-			}()
-			go func() {
-				var inst []string
-				e.ForEach("ul.breadcrumb > li", func(i int, e *colly.HTMLElement) {
-					inst = append(inst, e.Text)
-				})
-				instrumentation <- strings.Join(inst, ">")
-			}()
-			go func() {
-				var bookInfos []string
-				var contributors []Contributor
-				e.ForEach("div.short-facts > p", func(i int, e *colly.HTMLElement) {
-					if role := e.ChildText("span.role"); role != "" {
-						link := e.ChildAttr("a", "href")
-						if link != "" {
-							link = e.Request.AbsoluteURL(link)
-						} else {
-							link = "nil"
-						}
-						contributors = append(contributors, Contributor{
-							strings.TrimSpace(strings.TrimSuffix(e.Text, role)),
-							strings.Trim(role, "()"),
-							link,
-						})
-					} else {
-						if e.Text != "" {
-							bookInfos = append(bookInfos, e.Text)
-						}
-						if strings.HasPrefix(e.Text, "HN ") {
-							tmp := strings.Split(e.Text, "·")
-							hn_, _ := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(tmp[0], "HN ")))
-							hn <- hn_
-							ismn <- strings.TrimPrefix(tmp[1], " ISMN ")
-						}
-					}
-				})
-				bookInfo <- strings.Join(bookInfos, "\\n")
-				authors <- contributors
-			}()
-			return false
-		})
-
-		detailsChan := make(chan []Detail, 1)
-		// collect 'Detail' difficulty information
-		e.ForEachWithBreak("div.article-contents", func(i int, e *colly.HTMLElement) bool {
-			go func() {
-				section := "nil"
-				var details []Detail
-				e.ForEach("ul", func(i int, e *colly.HTMLElement) {
-					// skip table header
-					if i == 0 {
-						return
-					}
-					if tmp := e.ChildText("li.column-title > strong"); tmp != "" {
-						// section
-						section = tmp
-						details = append(details, Detail{
-							section,
-							"nil",
-							nil,
-							"I am the section",
-							"nil",
-						})
-					} else {
-						// title
-						var title string
-						composer := e.ChildText("li.column-title > em")
-						if composer != "" {
-							title = e.DOM.Find("li.column-title").Contents().Not("em").Text()
-						} else {
-							title = e.ChildText("li.column-title")
-						}
-						instrument := strings.Split(e.ChildText("li.column-difficulty"), " ")[0]
-						difficulty := instrument + " " + e.ChildText("li.column-difficulty > span.grade-circle")
-						var abrsm []string
-						e.ForEach("li.column-difficulty > a", func(i int, e *colly.HTMLElement) {
-							abrsm = append(abrsm, e.Text)
-						})
-						details = append(details, Detail{
-							title,
-							difficulty,
-							abrsm,
-							section,
-							composer,
-						})
-					}
-				})
-				detailsChan <- details
-			}()
-			return false
-		})
-
-		book := Book{
-			URL:             e.Request.URL.String(),
-			Title:           <-title,
-			Composer:        <-composer,
-			Authors:         <-authors,
-			Price:           <-price,
-			Instrumentation: <-instrumentation,
-			BookInfo:        <-bookInfo,
-			HN:              <-hn,
-			ISMN:            <-ismn,
-			Description:     <-description,
-			Details:         <-detailsChan,
-			CoverLink:       <-coverLink,
+	c2.OnResponse(func(response *colly.Response) {
+		book, err := ParseBookDetail(bytes.NewReader(response.Body), response.Request.URL)
+		if err != nil {
+			fmt.Fprintf(stdout, "ParseBookDetail %s error: %s\n", response.Request.URL, err)
+			report.Error(err)
+			return
 		}
-		//fmt.Println("Sending a book")
-		*books <- book
-		//fmt.Println("Sent a book")
-	})
-}
-
-// mulBooks multiplexes a channel of books into num channels of books
-func mulBooks(books *chan Book, num int) []*chan Book {
-	consumers := make([]*chan Book, num)
-	for i := 0; i < num; i++ {
-		cons := make(chan Book, 10)
-		consumers = append(consumers, &cons)
-	}
-
-	var o = sync.Once{}
-	go o.Do(func() {
-		go func() {
-			for v := range *books {
-				for _, cons := range consumers {
-					*cons <- v
-				}
+		if opts.completed(book.HN) {
+			fmt.Fprintf(stdout, "Skipping HN %04d completed before\n", book.HN)
+			return
+		}
+		// e.g. a result without HN, or the same book under another title in its URL
+		if book.HN != 0 && !written.add(book.HN) {
+			fmt.Fprintf(stdout, "Skipping HN %04d of %s scraped before\n", book.HN, book.URL)
+			return
+		}
+		for field, selector := range bookFieldSelectors {
+			report.Selector(selector, containsString(book.Missing, field))
+		}
+		if len(opts.Languages) > 0 {
+			localizeBook(c3, &book, opts.Languages, stdout)
+		}
+		if opts.Covers.Dir != "" {
+			if err := downloadCover(c4, &book, opts.Covers, report, stdout); err != nil {
+				fmt.Fprintf(stdout, "downloadCover %s error: %s\n", book.URL, err)
+				report.Error(err)
+				book.addFieldError("Cover", err)
 			}
-		}()
+		}
+		if book.Partial() {
+			fmt.Fprintf(stdout, "Partial book %s missing %v, errors %v\n", book.URL, book.Missing, book.FieldErrors)
+			report.Count("partial books", 1)
+		}
+		if err := sink.Write(book); err != nil {
+			fmt.Fprintf(stdout, "sink.Write %s error: %s\n", book.URL, err)
+			report.Error(err)
+		}
+		opts.complete(book.HN)
+		report.Count("books", 1)
 	})
-	return consumers
 }
 
-func ScrapeBookDetails(mode string, verbose int, outFile *os.File, collection *mongo.Collection) {
+// ScrapeBookDetails crawls the search results of every query in opts and writes
+// the details of each book found to every sink.
+// The sinks are closed when the crawl is done; the returned error is a FanOutError listing the sinks that failed.
+func ScrapeBookDetails(verbose int, opts CrawlOptions, sinks ...BookSink) error {
 	var verbout io.Writer
 	switch verbose {
 	case 0:
@@ -308,62 +215,37 @@ func ScrapeBookDetails(mode string, verbose int, outFile *os.File, collection *m
 		verbout = os.Stdout
 	}
 
-	// Channel to collect books
-	books := make(chan Book, 10)
-	done := make(chan bool, 1)
-	switch mode {
-	case "csv":
-		go writeToCsv(&books, &done, outFile)
-	case "json":
-		go writeToJson(&books, &done, outFile)
-	case "mongo":
-		go writeToMongo(&books, &done, collection)
-	case "mongo-csv":
-		booksCopy := mulBooks(&books, 2)
-		done1 := make(chan bool, 1)
-		done2 := make(chan bool, 1)
-		go writeToMongo(booksCopy[0], &done1, collection)
-		go writeToCsv(booksCopy[1], &done2, outFile)
-		go func() {
-			select {
-			case <-done1:
-				fmt.Println("Finished writing to mongo")
-				<-done2
-				fmt.Println("Finished writing to CSV")
-			case <-done2:
-				fmt.Println("Finished writing to CSV")
-				<-done1
-				fmt.Println("Finished writing to mongo")
-			}
-			done <- true
-		}()
-	default:
-		panic("Unrecognized mode")
-	}
+	out := NewFanOut(10, sinks...)
+	report := crawl.NewReport("henle details")
 
 	// Instantiate default collector
-	c := colly.NewCollector(
-		// Visit only domains: www.henle.de
-		colly.AllowedDomains("www.henle.de"),
-		colly.CacheDir("../../cache"),
-	)
+	c, err := opts.newCollector()
+	if err != nil {
+		return err
+	}
+	if err := opts.Politeness.Apply(c, 2, report); err != nil {
+		return err
+	}
 
 	// Create another collector to scrape henle book details
 	c2 := c.Clone()
+	opts.Politeness.HandleRetries(c2, report)
 
-	// Limit the number of threads started by colly to two
-	// when visiting links which domains' matches "*httpbin.*" glob
-	c2.Limit(&colly.LimitRule{
-		DomainGlob:  "*henle.*",
-		Parallelism: 2, // max collectors running
-		//Delay:       2 * time.Second,  // delay between each call. If collectors finish before delay, only parallelism=1.
-	})
+	setupBookDetailCollectors(c, c2, out, opts, report, verbout)
+	queries := opts.queries()
+	stats := newSearchStats(queries)
+	setupSearchPagination(c, stats, opts.MaxPages, verbout)
 
-	setupBookDetailCollectors(c, c2, &books, verbout)
+	// Continue an interrupted crawl
+	for _, err := range opts.resume(map[string]*colly.Collector{"search": c, "details": c2}) {
+		log.Println("resume error:", err)
+	}
 
 	// Start scraping on ...
 	// List View
-	err := c.Visit("https://www.henle.de/en/search/?Scoring=Keyboard+instruments&Instrument=Piano+solo")
+	for _, err := range visitSearchPages(c, queries) {
+		log.Println("c.Visit error:", err)
+	}
 
 	// Detail View: Just 1 title
 	//err := c2.Visit("https://www.henle.de/en/detail/?Title=Allegro+barbaro_1400")
@@ -375,11 +257,16 @@ func ScrapeBookDetails(mode string, verbose int, outFile *os.File, collection *m
 	//err := c2.Visit("https://www.henle.de/en/detail/?Title=Piano+Sonata+no.+26+E+flat+major+op.+81a+%28Les+Adieux%29_1223")
 	// String instruments > Violin and Piano. Content has Authors.
 	//err := c2.Visit("https://www.henle.de/en/detail/?Title=Volume+II_353")
-	if err != nil {
-		log.Println("c.Visit error:", err)
-	}
 
 	c2.Wait()
-	close(books)
-	<-done
+	fmt.Println(stats)
+	err = out.Close()
+	if err != nil {
+		report.Error(err)
+	}
+	report.Set("search", stats.Queries)
+	if err := report.WriteFile(opts.reportFile("henle-details-report.json")); err != nil {
+		log.Println("report.WriteFile error:", err)
+	}
+	return err
 }
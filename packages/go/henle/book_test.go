@@ -0,0 +1,75 @@
+package henle
+
+import (
+	"github.com/bluemonarch21/matchmaker/crawl"
+	"github.com/gocolly/colly"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// bookList is a BookSink keeping the books in memory.
+type bookList struct {
+	books []Book
+}
+
+func (l *bookList) Write(book Book) error {
+	l.books = append(l.books, book)
+	return nil
+}
+
+func (l *bookList) Close() error {
+	return nil
+}
+
+func TestBookDetailsSkipsParsedDuplicates(t *testing.T) {
+	testdata, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the search pages are saved to the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// the two results of HN 1400 and 782 on the search page lead to the same book, HN 1400
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/en/search/":
+			http.ServeFile(w, r, filepath.Join(testdata, "search-results.html"))
+		case "/en/detail/":
+			http.ServeFile(w, r, filepath.Join(testdata, "detail-single-title.html"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	c := colly.NewCollector()
+	c2 := c.Clone()
+	sink := &bookList{}
+	report := crawl.NewReport("test")
+	setupBookDetailCollectors(c, c2, sink, CrawlOptions{}, report, io.Discard)
+	if err := c.Visit(server.URL + "/en/search/"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.books) != 1 || sink.books[0].HN != 1400 {
+		var hns []int
+		for _, book := range sink.books {
+			hns = append(hns, book.HN)
+		}
+		t.Errorf("got books of HNs %v, want HN 1400 once", hns)
+	}
+	if report.Items["books"] != 1 {
+		t.Errorf("got %d books in the report, want 1", report.Items["books"])
+	}
+}
@@ -0,0 +1,215 @@
+package henle
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bluemonarch21/matchmaker/crawl"
+	"github.com/bluemonarch21/matchmaker/imaging"
+	"github.com/gocolly/colly"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// CoverOptions configures the download of book covers by ScrapeBookDetails.
+type CoverOptions struct {
+	// Dir is where covers are saved, as henle/<HN>/cover.jpg (or .png, .gif). Covers are not downloaded if empty.
+	Dir string
+	// ThumbnailWidths are the widths of the thumbnails saved next to each cover, as cover-w<width>.jpg.
+	ThumbnailWidths []int
+}
+
+// CoverImage is a downloaded book cover. Its metadata is also saved as cover.json next to the cover,
+// so a later crawl skips the covers whose stored hash is still current.
+type CoverImage struct {
+	File         string // path relative to CoverOptions.Dir, e.g. "henle/1223/cover.jpg"
+	Format       string // "jpeg", "png" or "gif"
+	Width        int
+	Height       int
+	SHA256       string // hex encoded hash of File
+	DHash        string // perceptual hash of File, see HashBookImages, "" until computed
+	ETag         string // validators of the response, sent with the next request of the cover
+	LastModified string
+	Thumbnails   []CoverThumbnail
+}
+
+// CoverThumbnail is a JPEG thumbnail of a cover.
+type CoverThumbnail struct {
+	File   string // path relative to CoverOptions.Dir
+	Width  int
+	Height int
+}
+
+// thumbnailQuality is the JPEG quality of cover thumbnails.
+const thumbnailQuality = 85
+
+// coverExtensions are the file extensions of the image formats.
+var coverExtensions = map[string]string{"jpeg": ".jpg", "png": ".png", "gif": ".gif"}
+
+// setupCoverCollector makes c put the body of the covers it downloads into the "cover" value of the request context,
+// and the "not modified" value when the stored cover is still current.
+// c must not be asynchronous, so the cover is available as soon as the request returns.
+func setupCoverCollector(c *colly.Collector, stdout io.Writer) {
+	c.OnRequest(func(r *colly.Request) {
+		fmt.Fprintln(stdout, "c4 Visiting", r.URL.String())
+	})
+	c.OnResponse(func(response *colly.Response) {
+		response.Ctx.Put("cover", response)
+	})
+	c.OnError(func(response *colly.Response, err error) {
+		if response.StatusCode == http.StatusNotModified {
+			response.Ctx.Put("not modified", "true")
+		}
+	})
+}
+
+// downloadCover downloads the cover of book with c into the directory of opts and sets book.Cover.
+// A stored cover that Henle reports as not modified, or that is downloaded again with the same hash, is not rewritten.
+// c must have been set up with setupCoverCollector.
+func downloadCover(c *colly.Collector, book *Book, opts CoverOptions, report *crawl.Report, stdout io.Writer) error {
+	if book.CoverLink == "" || book.HN == 0 {
+		return nil
+	}
+	dir := path.Join("henle", fmt.Sprintf("%04d", book.HN))
+	stored, err := readStoredCover(opts.Dir, dir)
+	if err != nil {
+		return err
+	}
+
+	ctx := colly.NewContext()
+	header := http.Header{}
+	if stored != nil {
+		if stored.ETag != "" {
+			header.Set("If-None-Match", stored.ETag)
+		}
+		if stored.LastModified != "" {
+			header.Set("If-Modified-Since", stored.LastModified)
+		}
+	}
+	if err := c.Request("GET", book.CoverLink, nil, ctx, header); err != nil && ctx.Get("not modified") == "" {
+		return fmt.Errorf("cover %s: %w", book.CoverLink, err)
+	}
+
+	var cover CoverImage
+	var data []byte
+	if response, ok := ctx.GetAny("cover").(*colly.Response); ok {
+		_, info, err := imaging.Decode(response.Body)
+		if err != nil {
+			return fmt.Errorf("cover %s: %w", book.CoverLink, err)
+		}
+		ext, ok := coverExtensions[info.Format]
+		if !ok {
+			return fmt.Errorf("cover %s: unsupported format %s", book.CoverLink, info.Format)
+		}
+		cover = CoverImage{
+			File:         path.Join(dir, "cover"+ext),
+			Format:       info.Format,
+			Width:        info.Width,
+			Height:       info.Height,
+			SHA256:       info.SHA256,
+			ETag:         response.Headers.Get("ETag"),
+			LastModified: response.Headers.Get("Last-Modified"),
+		}
+		if stored != nil && stored.SHA256 == cover.SHA256 && stored.File == cover.File {
+			cover.Thumbnails = stored.Thumbnails
+			cover.DHash = stored.DHash
+			report.Count("covers unchanged", 1)
+		} else {
+			if err := os.MkdirAll(filepath.Join(opts.Dir, filepath.FromSlash(dir)), 0755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(opts.Dir, filepath.FromSlash(cover.File)), response.Body, 0644); err != nil {
+				return err
+			}
+			data = response.Body
+			fmt.Fprintf(stdout, "Cover of HN %04d saved to %s\n", book.HN, cover.File)
+			report.Count("covers", 1)
+		}
+	} else if stored != nil {
+		cover = *stored
+		report.Count("covers unchanged", 1)
+	} else {
+		return fmt.Errorf("cover %s: no response", book.CoverLink)
+	}
+
+	if err := updateThumbnails(&cover, data, opts); err != nil {
+		return fmt.Errorf("thumbnails of %s: %w", cover.File, err)
+	}
+	if err := writeStoredCover(opts.Dir, dir, cover); err != nil {
+		return err
+	}
+	book.Cover = &cover
+	return nil
+}
+
+// updateThumbnails sets the thumbnails of cover to those of the widths of opts, creating the missing ones.
+// data is the cover just downloaded, nil if it is the stored one, which is only read when a thumbnail is missing.
+func updateThumbnails(cover *CoverImage, data []byte, opts CoverOptions) error {
+	existing := make(map[string]CoverThumbnail)
+	if data == nil {
+		for _, t := range cover.Thumbnails {
+			if _, err := os.Stat(filepath.Join(opts.Dir, filepath.FromSlash(t.File))); err == nil {
+				existing[t.File] = t
+			}
+		}
+	}
+	var thumbnails []CoverThumbnail
+	var img image.Image
+	for _, width := range opts.ThumbnailWidths {
+		file := path.Join(path.Dir(cover.File), fmt.Sprintf("cover-w%d.jpg", width))
+		if t, ok := existing[file]; ok {
+			thumbnails = append(thumbnails, t)
+			continue
+		}
+		if img == nil {
+			var err error
+			if data == nil {
+				if data, err = os.ReadFile(filepath.Join(opts.Dir, filepath.FromSlash(cover.File))); err != nil {
+					return err
+				}
+			}
+			if img, _, err = imaging.Decode(data); err != nil {
+				return err
+			}
+		}
+		info, err := imaging.WriteJPEG(filepath.Join(opts.Dir, filepath.FromSlash(file)), imaging.Thumbnail(img, width), thumbnailQuality)
+		if err != nil {
+			return err
+		}
+		thumbnails = append(thumbnails, CoverThumbnail{file, info.Width, info.Height})
+	}
+	cover.Thumbnails = thumbnails
+	return nil
+}
+
+// readStoredCover reads the cover.json of the cover directory dir, relative to root.
+// It returns nil if there is none, or if the stored cover file does not match its hash.
+func readStoredCover(root string, dir string) (*CoverImage, error) {
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(dir), "cover.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var cover CoverImage
+	if err := json.Unmarshal(data, &cover); err != nil {
+		return nil, nil
+	}
+	hash, err := imaging.FileSHA256(filepath.Join(root, filepath.FromSlash(cover.File)))
+	if err != nil || hash != cover.SHA256 {
+		return nil, nil
+	}
+	return &cover, nil
+}
+
+// writeStoredCover writes the metadata of cover to the cover.json of the cover directory dir, relative to root.
+func writeStoredCover(root string, dir string, cover CoverImage) error {
+	data, err := json.MarshalIndent(cover, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(root, filepath.FromSlash(dir), "cover.json"), data, 0644)
+}
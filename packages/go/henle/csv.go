@@ -0,0 +1,249 @@
+package henle
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// CSVSchemaVersion is the version of the CSV columns, written in the schema_version column of every row.
+// It is increased whenever columns are added, removed or change meaning.
+const CSVSchemaVersion = 1
+
+// CSVLayout selects the rows written by a CSVSink.
+type CSVLayout int
+
+const (
+	// DetailRows writes one row per piece and movement, repeating the book columns.
+	// Books without pieces get one row with empty detail columns.
+	DetailRows CSVLayout = iota
+	// BookRows writes one row per book, with the sections nested as a JSON array.
+	BookRows
+)
+
+// ParseCSVLayout parses "detail" or "book".
+func ParseCSVLayout(s string) (CSVLayout, error) {
+	switch s {
+	case "detail":
+		return DetailRows, nil
+	case "book":
+		return BookRows, nil
+	}
+	return 0, fmt.Errorf("invalid CSV layout %q", s)
+}
+
+var bookColumns = []string{
+	"schema_version",
+	"hn",
+	"ismn",
+	"url",
+	"title",
+	"composer",
+	"price",
+	"instrumentation",
+	"book_info",
+	"description",
+	"cover_link",
+	"cover_file",
+	"cover_width",
+	"cover_height",
+	"cover_sha256",
+	"authors",
+}
+
+var detailColumns = []string{
+	"detail_index",
+	"section",
+	"parent_title",
+	"detail_title",
+	"detail_composer",
+	"henle_difficulty",
+	"difficulties",
+	"abrsm_difficulty",
+	"abrsm_grades",
+	"work_type",
+	"work_number",
+	"work_key",
+	"work_mode",
+	"work_catalogues",
+	"work_nickname",
+}
+
+var contributorColumns = []string{
+	"schema_version",
+	"hn",
+	"name",
+	"role",
+	"url",
+	"person_id",
+}
+
+// CSVSink writes books as CSV with a header row and a fixed set of columns.
+// Lists inside a cell are joined with "|". The authors column lists "Name (Role)" of each
+// contributor; the full contributors can be written to a companion CSV joined by the hn column.
+type CSVSink struct {
+	layout       CSVLayout
+	writer       *csv.Writer
+	contributors *csv.Writer
+}
+
+// NewCSVSink returns a CSVSink writing rows of layout to w, and the contributors of every book
+// to contributors if it is not nil, after writing the header rows. Closing the sink does not close the writers.
+func NewCSVSink(w io.Writer, layout CSVLayout, contributors io.Writer) (*CSVSink, error) {
+	s := &CSVSink{layout: layout, writer: csv.NewWriter(w)}
+	header := append([]string{}, bookColumns...)
+	if layout == DetailRows {
+		header = append(header, detailColumns...)
+	} else {
+		header = append(header, "sections")
+	}
+	if err := s.writer.Write(header); err != nil {
+		return nil, err
+	}
+	if contributors != nil {
+		s.contributors = csv.NewWriter(contributors)
+		if err := s.contributors.Write(contributorColumns); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *CSVSink) Write(book Book) error {
+	switch s.layout {
+	case DetailRows:
+		var err error
+		i := 0
+		book.walkDetails(func(detail Detail, parent *Detail) {
+			if err == nil {
+				err = s.writer.Write(append(bookRow(book), detailRow(i, detail, parent)...))
+			}
+			i++
+		})
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			// books without details, e.g. partial books with missing fields, still get a row
+			if err := s.writer.Write(append(bookRow(book), make([]string, len(detailColumns))...)); err != nil {
+				return err
+			}
+		}
+	case BookRows:
+		nested, err := json.Marshal(book.Sections)
+		if err != nil {
+			return err
+		}
+		if err := s.writer.Write(append(bookRow(book), string(nested))); err != nil {
+			return err
+		}
+	}
+	if s.contributors != nil {
+		for _, author := range book.Authors {
+			row := []string{
+				strconv.Itoa(CSVSchemaVersion),
+				strconv.Itoa(book.HN),
+				author.Name,
+				author.Role,
+				author.URL,
+				author.PersonID,
+			}
+			if err := s.contributors.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	return s.flush()
+}
+
+func (s *CSVSink) Close() error {
+	return s.flush()
+}
+
+func (s *CSVSink) flush() error {
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		return err
+	}
+	if s.contributors != nil {
+		s.contributors.Flush()
+		return s.contributors.Error()
+	}
+	return nil
+}
+
+func bookRow(book Book) []string {
+	authors := make([]string, len(book.Authors))
+	for i, author := range book.Authors {
+		authors[i] = fmt.Sprintf("%s (%s)", author.Name, author.Role)
+	}
+	var cover [4]string
+	if book.Cover != nil {
+		cover = [4]string{book.Cover.File, strconv.Itoa(book.Cover.Width), strconv.Itoa(book.Cover.Height), book.Cover.SHA256}
+	}
+	return []string{
+		strconv.Itoa(CSVSchemaVersion),
+		strconv.Itoa(book.HN),
+		book.ISMN,
+		book.URL,
+		book.Title,
+		book.Composer,
+		book.Price,
+		book.Instrumentation,
+		book.BookInfo,
+		book.Description,
+		book.CoverLink,
+		cover[0],
+		cover[1],
+		cover[2],
+		cover[3],
+		strings.Join(authors, "|"),
+	}
+}
+
+// detailRow returns the columns of the i-th detail of a book, a movement of parent if that is not nil.
+func detailRow(i int, detail Detail, parent *Detail) []string {
+	parentTitle := ""
+	if parent != nil {
+		parentTitle = parent.Title
+	}
+	row := []string{
+		strconv.Itoa(i),
+		detail.Section,
+		parentTitle,
+		detail.Title,
+		detail.Composer,
+		detail.HenleDifficulty,
+	}
+	difficulties := make([]string, len(detail.Difficulties))
+	for i, d := range detail.Difficulties {
+		difficulties[i] = d.String()
+	}
+	grades := make([]string, len(detail.ABRSMGrades))
+	for i, g := range detail.ABRSMGrades {
+		grades[i] = g.String()
+	}
+	row = append(row,
+		strings.Join(difficulties, "|"),
+		strings.Join(detail.ABRSMDifficulty, "|"),
+		strings.Join(grades, "|"),
+	)
+	catalogues := make([]string, len(detail.Work.Catalogues))
+	for i, c := range detail.Work.Catalogues {
+		catalogues[i] = c.String()
+	}
+	return append(row,
+		detail.Work.Type,
+		detail.Work.Number,
+		detail.Work.Key,
+		detail.Work.Mode,
+		strings.Join(catalogues, "|"),
+		detail.Work.Nickname,
+	)
+}
@@ -0,0 +1,63 @@
+package henle
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"testing"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestNewCSVSinkHeaderError(t *testing.T) {
+	if _, err := NewCSVSink(failingWriter{}, DetailRows, nil); err == nil {
+		t.Error("got no error writing the header")
+	}
+	if _, err := NewCSVSink(&bytes.Buffer{}, BookRows, failingWriter{}); err == nil {
+		t.Error("got no error writing the contributors header")
+	}
+}
+
+func TestCSVSinkDetailRowsPartialBook(t *testing.T) {
+	var buf bytes.Buffer
+	sink, err := NewCSVSink(&buf, DetailRows, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := Book{HN: 185, URL: "https://www.henle.de/en/detail/?Title=Nocturnes_185", Missing: []string{"Sections"}}
+	if err := sink.Write(book); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want the header and one row of the book", len(rows))
+	}
+	header, row := rows[0], rows[1]
+	for i, column := range header {
+		switch column {
+		case "hn":
+			if row[i] != "185" {
+				t.Errorf("hn: got %q, want 185", row[i])
+			}
+		case "url":
+			if row[i] != book.URL {
+				t.Errorf("url: got %q, want %q", row[i], book.URL)
+			}
+		}
+	}
+	for i := len(bookColumns); i < len(header); i++ {
+		if row[i] != "" {
+			t.Errorf("%s: got %q, want an empty detail column", header[i], row[i])
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package henle
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Difficulty is the Henle difficulty of one instrument, from 1 (easy) to 9 (very difficult).
+// A range such as "5-6" has Min 5 and Max 6, a single level has Min equal to Max.
+type Difficulty struct {
+	Instrument string
+	Min        int
+	Max        int
+	Raw        string // level as shown on the page, e.g. "5-6"
+}
+
+var difficultyPattern = regexp.MustCompile(`^(\d)(?:\s*[-–]\s*(\d))?$`)
+
+// ParseDifficulty parses the level text of a grade circle, e.g. "5" or "5-6", of the given instrument.
+func ParseDifficulty(instrument string, level string) (Difficulty, error) {
+	level = strings.TrimSpace(level)
+	d := Difficulty{Instrument: instrument, Raw: level}
+	m := difficultyPattern.FindStringSubmatch(level)
+	if m == nil {
+		return d, fmt.Errorf("invalid Henle difficulty %q", level)
+	}
+	d.Min, _ = strconv.Atoi(m[1])
+	d.Max = d.Min
+	if m[2] != "" {
+		d.Max, _ = strconv.Atoi(m[2])
+	}
+	if d.Min < 1 || d.Max > 9 || d.Min > d.Max {
+		return d, fmt.Errorf("Henle difficulty %q out of range 1-9", level)
+	}
+	return d, nil
+}
+
+// Level returns the level as "5" or "5-6".
+func (d Difficulty) Level() string {
+	if d.Min == d.Max {
+		return strconv.Itoa(d.Min)
+	}
+	return fmt.Sprintf("%d-%d", d.Min, d.Max)
+}
+
+func (d Difficulty) String() string {
+	return d.Instrument + " " + d.Level()
+}
+
+// ABRSMGrade is an exam grade listed next to a Henle difficulty,
+// e.g. "ABRSM Grade 3 (2021-2022) List B".
+type ABRSMGrade struct {
+	Board     string // "ABRSM"
+	Grade     int    // 1 to 8, 0 for grades without a number such as diplomas
+	Name      string // "Grade 3", "ARSM", "DipABRSM", ...
+	FirstYear int    // first year of the syllabus, 0 if not listed
+	LastYear  int    // last year of the syllabus, 0 if not listed
+	List      string // syllabus list letter, "" if not listed
+	Raw       string
+}
+
+var (
+	abrsmPattern      = regexp.MustCompile(`^(\S+)\s+(.*?)(?:\s*\((\d{4})(?:\s*[-–/]\s*(\d{2,4}))?\))?(?:\s*List\s+([A-Z]))?$`)
+	abrsmGradePattern = regexp.MustCompile(`^Grade\s+(\d)$`)
+)
+
+// ParseABRSMGrade parses the text of an ABRSM grade link.
+func ParseABRSMGrade(text string) (ABRSMGrade, error) {
+	text = strings.TrimSpace(text)
+	g := ABRSMGrade{Raw: text}
+	m := abrsmPattern.FindStringSubmatch(text)
+	if m == nil || m[2] == "" {
+		return g, fmt.Errorf("invalid ABRSM grade %q", text)
+	}
+	g.Board = m[1]
+	g.Name = m[2]
+	if gm := abrsmGradePattern.FindStringSubmatch(g.Name); gm != nil {
+		g.Grade, _ = strconv.Atoi(gm[1])
+	}
+	if m[3] != "" {
+		g.FirstYear, _ = strconv.Atoi(m[3])
+		g.LastYear = g.FirstYear
+	}
+	if m[4] != "" {
+		g.LastYear, _ = strconv.Atoi(m[4])
+		if len(m[4]) == 2 {
+			// "2021-22"
+			g.LastYear += g.FirstYear / 100 * 100
+		}
+	}
+	g.List = m[5]
+	return g, nil
+}
+
+// Syllabus returns the syllabus years as "2021-2022", or "" if not listed.
+func (g ABRSMGrade) Syllabus() string {
+	switch {
+	case g.FirstYear == 0:
+		return ""
+	case g.FirstYear == g.LastYear:
+		return strconv.Itoa(g.FirstYear)
+	default:
+		return fmt.Sprintf("%d-%d", g.FirstYear, g.LastYear)
+	}
+}
+
+// String returns the grade as "board:name:syllabus:list", e.g. "ABRSM:Grade 3:2021-2022:B".
+func (g ABRSMGrade) String() string {
+	return strings.Join([]string{g.Board, g.Name, g.Syllabus(), g.List}, ":")
+}
@@ -0,0 +1,294 @@
+package henle
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bluemonarch21/matchmaker/imaging"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultDuplicateDistance is the largest Hamming distance between the hashes of near-duplicate images
+// used when none is given.
+const DefaultDuplicateDistance = 4
+
+// HashedImage is a downloaded preview page or cover with its perceptual hash.
+type HashedImage struct {
+	HN    int
+	Kind  string // "page" or "cover"
+	Voice string // directory of the voice of a page, "" for the score
+	Page  int    // page number, 0 for covers
+	File  string // path relative to the directory the image was downloaded to, e.g. "henle/0650/w1500/0001.jpg"
+	DHash string
+}
+
+// DuplicateCluster is a group of near-duplicate images of more than one book.
+type DuplicateCluster struct {
+	HNs    []int
+	Images []HashedImage
+}
+
+// DuplicateReport lists the clusters of near-duplicate images found by FindDuplicates.
+type DuplicateReport struct {
+	MaxDistance int
+	Images      int // number of images compared
+	Clusters    []DuplicateCluster
+}
+
+// fileDHash returns the formatted perceptual hash of an image file, see imaging.DHash.
+func fileDHash(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	img, _, err := imaging.Decode(data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", filename, err)
+	}
+	return imaging.FormatHash(imaging.DHash(img)), nil
+}
+
+// hashPages sets the missing perceptual hashes of the pages in the manifest of the book hn under outDir
+// and returns the widest variant of every page. The manifest is rewritten if a hash was added.
+// Pages that cannot be hashed are written to stdout and left out.
+func hashPages(outDir string, hn int, stdout io.Writer) ([]HashedImage, error) {
+	filename := manifestFile(outDir, fmt.Sprintf("%04d", hn))
+	manifest, err := ReadImageManifest(filename)
+	if err != nil {
+		return nil, err
+	}
+	bookDir := BookDir(outDir, hn)
+	changed := false
+	widest := make(map[string]PageImage)
+	var keys []string
+	for i, page := range manifest.Pages {
+		if page.DHash == "" {
+			hash, err := fileDHash(filepath.Join(bookDir, filepath.FromSlash(page.File)))
+			if err != nil {
+				fmt.Fprintf(stdout, "Hashing HN %04d page %s error: %s\n", hn, page.File, err)
+				continue
+			}
+			manifest.Pages[i].DHash = hash
+			page.DHash = hash
+			changed = true
+		}
+		key := fmt.Sprintf("%s/%04d", page.Voice, page.Page)
+		if w, ok := widest[key]; !ok {
+			keys = append(keys, key)
+			widest[key] = page
+		} else if page.Width > w.Width {
+			widest[key] = page
+		}
+	}
+	if changed {
+		if err := manifest.WriteFile(filename); err != nil {
+			return nil, err
+		}
+	}
+	images := make([]HashedImage, len(keys))
+	for i, key := range keys {
+		page := widest[key]
+		images[i] = HashedImage{
+			HN:    hn,
+			Kind:  "page",
+			Voice: page.Voice,
+			Page:  page.Page,
+			File:  path.Join("henle", fmt.Sprintf("%04d", hn), page.File),
+			DHash: page.DHash,
+		}
+	}
+	return images, nil
+}
+
+// hashCover sets the missing perceptual hash of the cover of the book hn under coversDir, in its cover.json.
+// It returns nil if the book has no current cover.
+func hashCover(coversDir string, hn int) (*HashedImage, error) {
+	dir := path.Join("henle", fmt.Sprintf("%04d", hn))
+	cover, err := readStoredCover(coversDir, dir)
+	if err != nil || cover == nil {
+		return nil, err
+	}
+	if cover.DHash == "" {
+		if cover.DHash, err = fileDHash(filepath.Join(coversDir, filepath.FromSlash(cover.File))); err != nil {
+			return nil, err
+		}
+		if err := writeStoredCover(coversDir, dir, *cover); err != nil {
+			return nil, err
+		}
+	}
+	return &HashedImage{HN: hn, Kind: "cover", File: cover.File, DHash: cover.DHash}, nil
+}
+
+// HashBookImages computes the perceptual hashes of the preview pages of every book hns downloaded under outDir,
+// or of every downloaded book if empty, and of their covers under coversDir if it is not empty.
+// The hashes are stored in the manifest.json of the pages and the cover.json of the covers,
+// so only new images are decoded. Every page is returned once, in its widest variant.
+func HashBookImages(outDir string, coversDir string, hns []int, stdout io.Writer) ([]HashedImage, error) {
+	if len(hns) == 0 {
+		var err error
+		if hns, err = DownloadedHNs(outDir); err != nil {
+			return nil, err
+		}
+		if coversDir != "" && coversDir != outDir {
+			// books with a cover but no preview
+			covered, _ := DownloadedHNs(coversDir)
+			hns = mergeHNs(hns, covered)
+		}
+	}
+	var images []HashedImage
+	for _, hn := range hns {
+		pages, err := hashPages(outDir, hn, stdout)
+		if err != nil {
+			fmt.Fprintf(stdout, "Hashing pages of HN %04d error: %s\n", hn, err)
+		}
+		images = append(images, pages...)
+		if coversDir == "" {
+			continue
+		}
+		cover, err := hashCover(coversDir, hn)
+		if err != nil {
+			fmt.Fprintf(stdout, "Hashing cover of HN %04d error: %s\n", hn, err)
+		} else if cover != nil {
+			images = append(images, *cover)
+		}
+	}
+	return images, nil
+}
+
+// mergeHNs returns the HNs of a and b, in increasing order.
+func mergeHNs(a []int, b []int) []int {
+	seen := make(map[int]bool)
+	var hns []int
+	for _, hn := range append(append([]int{}, a...), b...) {
+		if !seen[hn] {
+			seen[hn] = true
+			hns = append(hns, hn)
+		}
+	}
+	sort.Ints(hns)
+	return hns
+}
+
+// FindDuplicates groups the images whose hashes are at most maxDistance bits from the first image of the group,
+// and returns the groups spanning more than one book. Blank images are left out.
+// Every image joins the group of the closest such first image before it in images, or starts a new group,
+// so images that are only alike through a chain of others are not grouped.
+func FindDuplicates(images []HashedImage, maxDistance int) []DuplicateCluster {
+	var hashed []HashedImage
+	var hashes []uint64
+	for _, img := range images {
+		hash, err := imaging.ParseHash(img.DHash)
+		if err != nil || hash == 0 {
+			continue
+		}
+		hashed = append(hashed, img)
+		hashes = append(hashes, hash)
+	}
+
+	// index of the first image of the group of every image
+	var representatives []int
+	leader := make([]int, len(hashed))
+	for i := range hashes {
+		leader[i] = i
+		best := maxDistance + 1
+		for _, r := range representatives {
+			if d := imaging.HammingDistance(hashes[i], hashes[r]); d < best {
+				leader[i], best = r, d
+			}
+		}
+		if leader[i] == i {
+			representatives = append(representatives, i)
+		}
+	}
+
+	groups := make(map[int][]HashedImage)
+	for i, img := range hashed {
+		groups[leader[i]] = append(groups[leader[i]], img)
+	}
+	var clusters []DuplicateCluster
+	for _, group := range groups {
+		seen := make(map[int]bool)
+		var hns []int
+		for _, img := range group {
+			if !seen[img.HN] {
+				seen[img.HN] = true
+				hns = append(hns, img.HN)
+			}
+		}
+		if len(hns) < 2 {
+			continue
+		}
+		sort.Ints(hns)
+		sort.Slice(group, func(i, j int) bool {
+			a, b := group[i], group[j]
+			if a.HN != b.HN {
+				return a.HN < b.HN
+			}
+			if a.Kind != b.Kind {
+				return a.Kind < b.Kind
+			}
+			if a.Voice != b.Voice {
+				return a.Voice < b.Voice
+			}
+			return a.Page < b.Page
+		})
+		clusters = append(clusters, DuplicateCluster{HNs: hns, Images: group})
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		a, b := clusters[i].Images[0], clusters[j].Images[0]
+		if a.HN != b.HN {
+			return a.HN < b.HN
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Voice != b.Voice {
+			return a.Voice < b.Voice
+		}
+		return a.Page < b.Page
+	})
+	return clusters
+}
+
+// String describes the image, e.g. "HN 0650 p. 3", "HN 0650 violin p. 3" or "HN 0650 cover".
+func (img HashedImage) String() string {
+	s := fmt.Sprintf("HN %04d", img.HN)
+	if img.Kind == "cover" {
+		return s + " cover"
+	}
+	if img.Voice != "" {
+		s += " " + img.Voice
+	}
+	return fmt.Sprintf("%s p. %d", s, img.Page)
+}
+
+// WriteDuplicateReport hashes the images of the books hns, see HashBookImages, and writes the clusters
+// of near-duplicates across books at most maxDistance bits apart to filename as JSON, see FindDuplicates.
+func WriteDuplicateReport(outDir string, coversDir string, hns []int, maxDistance int, filename string, stdout io.Writer) error {
+	images, err := HashBookImages(outDir, coversDir, hns, stdout)
+	if err != nil {
+		return err
+	}
+	report := DuplicateReport{
+		MaxDistance: maxDistance,
+		Images:      len(images),
+		Clusters:    FindDuplicates(images, maxDistance),
+	}
+	for _, cluster := range report.Clusters {
+		names := make([]string, len(cluster.Images))
+		for i, img := range cluster.Images {
+			names[i] = img.String()
+		}
+		fmt.Fprintf(stdout, "Duplicates: %s\n", strings.Join(names, ", "))
+	}
+	fmt.Fprintf(stdout, "%d clusters of near-duplicates across books in %d images\n", len(report.Clusters), report.Images)
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
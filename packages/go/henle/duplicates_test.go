@@ -0,0 +1,101 @@
+package henle
+
+import (
+	"bytes"
+	"github.com/bluemonarch21/matchmaker/imaging"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindDuplicatesDoesNotChain(t *testing.T) {
+	const a = 0xf0f0f0f0f0f0f0f0
+	images := []HashedImage{
+		{HN: 1, Kind: "page", Page: 1, DHash: imaging.FormatHash(a)},
+		{HN: 2, Kind: "page", Page: 1, DHash: imaging.FormatHash(a ^ 0x7)},    // 3 bits from a
+		{HN: 3, Kind: "page", Page: 1, DHash: imaging.FormatHash(a ^ 0x3f)},   // 3 bits from the page of HN 2, 6 from a
+		{HN: 4, Kind: "page", Page: 1, DHash: imaging.FormatHash(a ^ 0x7f)},   // 1 bit from the page of HN 3
+		{HN: 5, Kind: "page", Page: 1, DHash: imaging.FormatHash(0)},          // blank
+		{HN: 6, Kind: "page", Page: 1, DHash: imaging.FormatHash(^uint64(a))}, // unlike any other
+	}
+	clusters := FindDuplicates(images, 4)
+	var got [][]int
+	for _, cluster := range clusters {
+		got = append(got, cluster.HNs)
+	}
+	want := [][]int{{1, 2}, {3, 4}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got clusters of HNs %v, want %v", got, want)
+	}
+}
+
+// gradientPNG returns a 64x64 PNG of a horizontal gradient.
+func gradientPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			img.SetGray(x, y, color.Gray{uint8(x * 4)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeBadPageBook writes the manifest and pages of the book 650 to a temporary directory and returns it:
+// a gradient on page 1, a file that is not an image on page 2 and no file for page 3.
+func writeBadPageBook(t *testing.T) string {
+	t.Helper()
+	outDir := t.TempDir()
+	bookDir := BookDir(outDir, 650)
+	if err := os.MkdirAll(filepath.Join(bookDir, "w1500"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bookDir, "w1500", "0001.jpg"), gradientPNG(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bookDir, "w1500", "0002.jpg"), []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := ImageManifest{HN: 650, Pages: []PageImage{
+		{Page: 1, Variant: "w1500", File: "w1500/0001.jpg"},
+		{Page: 2, Variant: "w1500", File: "w1500/0002.jpg"},
+		{Page: 3, Variant: "w1500", File: "w1500/0003.jpg"},
+	}}
+	if err := manifest.WriteFile(manifestFile(outDir, "0650")); err != nil {
+		t.Fatal(err)
+	}
+	return outDir
+}
+
+func TestHashPagesSkipsBadPage(t *testing.T) {
+	outDir := writeBadPageBook(t)
+	var stdout bytes.Buffer
+	images, err := hashPages(outDir, 650, &stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || images[0].Page != 1 || images[0].DHash == "" {
+		t.Errorf("got images %+v, want the hashed page 1", images)
+	}
+	for _, file := range []string{"w1500/0002.jpg", "w1500/0003.jpg"} {
+		if !strings.Contains(stdout.String(), file) {
+			t.Errorf("the bad page %s is not reported, output: %q", file, stdout.String())
+		}
+	}
+	stored, err := ReadImageManifest(manifestFile(outDir, "0650"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Pages[0].DHash == "" || stored.Pages[1].DHash != "" || stored.Pages[2].DHash != "" {
+		t.Errorf("got stored hashes %q, %q and %q, want only that of page 1", stored.Pages[0].DHash, stored.Pages[1].DHash, stored.Pages[2].DHash)
+	}
+}
@@ -0,0 +1,102 @@
+package henle
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DifficultyFilter selects the pieces and movements with a part of Instrument
+// whose Henle difficulty overlaps the levels Min to Max.
+// Instrument is compared case-insensitively and also matches numbered parts, e.g. "Violin" matches "Violin II".
+type DifficultyFilter struct {
+	Instrument string
+	Min        int
+	Max        int
+}
+
+// ParseDifficultyFilter parses "instrument:level", e.g. "Violin:5" or "Piano:4-6".
+func ParseDifficultyFilter(s string) (DifficultyFilter, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return DifficultyFilter{}, fmt.Errorf("invalid difficulty filter %q, expected instrument:level", s)
+	}
+	d, err := ParseDifficulty(strings.TrimSpace(s[:i]), s[i+1:])
+	if err != nil {
+		return DifficultyFilter{}, err
+	}
+	return DifficultyFilter{d.Instrument, d.Min, d.Max}, nil
+}
+
+func (f DifficultyFilter) String() string {
+	return Difficulty{Instrument: f.Instrument, Min: f.Min, Max: f.Max}.String()
+}
+
+// Match reports whether d is a part of the instrument of f at one of its levels.
+func (f DifficultyFilter) Match(d Difficulty) bool {
+	instrument := strings.ToLower(d.Instrument)
+	want := strings.ToLower(f.Instrument)
+	if instrument != want && !strings.HasPrefix(instrument, want+" ") {
+		return false
+	}
+	return d.Min <= f.Max && d.Max >= f.Min
+}
+
+// MatchDetail reports whether one of the parts of detail matches.
+func (f DifficultyFilter) MatchDetail(detail Detail) bool {
+	for _, d := range detail.Difficulties {
+		if f.Match(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Book returns book with only the matching pieces and movements, and whether there are any.
+// A piece is kept with its matching movements if it matches itself or one of its movements does.
+func (f DifficultyFilter) Book(book Book) (Book, bool) {
+	sections := book.Sections
+	book.Sections = nil
+	for _, section := range sections {
+		pieces := f.details(section.Pieces)
+		if len(pieces) > 0 {
+			book.Sections = append(book.Sections, Section{section.Title, pieces})
+		}
+	}
+	return book, len(book.Sections) > 0
+}
+
+func (f DifficultyFilter) details(details []Detail) []Detail {
+	var matching []Detail
+	for _, detail := range details {
+		movements := f.details(detail.Movements)
+		if f.MatchDetail(detail) || len(movements) > 0 {
+			detail.Movements = movements
+			matching = append(matching, detail)
+		}
+	}
+	return matching
+}
+
+// FilterSink is a BookSink writing the books with a matching piece or movement to another sink,
+// with only the matching pieces and movements, see DifficultyFilter.Book.
+// Wrap a MongoSink to insert only the matching books into mongodb.
+type FilterSink struct {
+	filter DifficultyFilter
+	sink   BookSink
+}
+
+// NewFilterSink returns a FilterSink writing to sink. Closing the FilterSink closes sink.
+func NewFilterSink(filter DifficultyFilter, sink BookSink) *FilterSink {
+	return &FilterSink{filter, sink}
+}
+
+func (s *FilterSink) Write(book Book) error {
+	if book, ok := s.filter.Book(book); ok {
+		return s.sink.Write(book)
+	}
+	return nil
+}
+
+func (s *FilterSink) Close() error {
+	return s.sink.Close()
+}
@@ -0,0 +1,205 @@
+package henle
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/bluemonarch21/matchmaker/iiif"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The IIIF resources of the downloaded books are laid out under a base URL as
+//
+//	{base}/iiif/henle/manifests/{HN}        Presentation API manifest of a book
+//	{base}/iiif/henle/images/{HN}-{page}    Image API service of a page, with info.json and full/{size}/0/default.jpg
+//
+// The server package serves this layout from the output directory of ScrapeBookImages.
+
+// IIIFManifestURL returns the id of the IIIF manifest of the book hn.
+func IIIFManifestURL(baseURL string, hn int) string {
+	return fmt.Sprintf("%s/iiif/henle/manifests/%04d", strings.TrimSuffix(baseURL, "/"), hn)
+}
+
+// IIIFImageURL returns the id of the IIIF image service of a page of the book hn.
+func IIIFImageURL(baseURL string, hn int, page int) string {
+	return fmt.Sprintf("%s/iiif/henle/images/%s", strings.TrimSuffix(baseURL, "/"), IIIFImageID(hn, page))
+}
+
+// IIIFImageID returns the identifier of a page of the book hn in its image service URL, e.g. "0650-0001".
+func IIIFImageID(hn int, page int) string {
+	return fmt.Sprintf("%04d-%04d", hn, page)
+}
+
+// ParseIIIFImageID parses an identifier returned by IIIFImageID.
+func ParseIIIFImageID(id string) (hn int, page int, err error) {
+	parts := strings.Split(id, "-")
+	if len(parts) == 2 {
+		if hn, err = strconv.Atoi(parts[0]); err == nil {
+			if page, err = strconv.Atoi(parts[1]); err == nil {
+				return hn, page, nil
+			}
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid image id %q", id)
+}
+
+// PageVariants returns the width variants of a page of the score in the manifest, the largest first.
+func (m ImageManifest) PageVariants(page int) []PageImage {
+	var variants []PageImage
+	for _, p := range m.Pages {
+		if p.Voice == "" && p.Page == page {
+			variants = append(variants, p)
+		}
+	}
+	sort.SliceStable(variants, func(i, j int) bool {
+		return variants[i].Width > variants[j].Width
+	})
+	return variants
+}
+
+// PageNumbers returns the numbers of the pages of the score in the manifest, in increasing order.
+func (m ImageManifest) PageNumbers() []int {
+	seen := make(map[int]bool)
+	var pages []int
+	for _, p := range m.Pages {
+		if p.Voice == "" && !seen[p.Page] {
+			seen[p.Page] = true
+			pages = append(pages, p.Page)
+		}
+	}
+	sort.Ints(pages)
+	return pages
+}
+
+// IIIFImageInfo returns the info.json of the image service of a page, listing the size of every width variant.
+func (m ImageManifest) IIIFImageInfo(baseURL string, page int) (iiif.ImageInfo, error) {
+	variants := m.PageVariants(page)
+	if len(variants) == 0 {
+		return iiif.ImageInfo{}, fmt.Errorf("page %d of HN %04d not found", page, m.HN)
+	}
+	sizes := make([]iiif.Size, len(variants))
+	for i, v := range variants {
+		sizes[i] = iiif.Size{Width: v.Width, Height: v.Height}
+	}
+	return iiif.NewImageInfo(IIIFImageURL(baseURL, m.HN, page), variants[0].Width, variants[0].Height, sizes), nil
+}
+
+// IIIFManifest returns the IIIF Presentation manifest of the downloaded pages of a book,
+// one canvas per page of the size of its largest width variant.
+// The label and metadata are taken from book, which may be nil, or else from m.Pageflip.
+func (m ImageManifest) IIIFManifest(baseURL string, book *Book) iiif.Manifest {
+	id := IIIFManifestURL(baseURL, m.HN)
+	label := fmt.Sprintf("HN %04d", m.HN)
+	if book != nil && book.Title != "" {
+		label = book.Title
+	} else if book == nil && m.Pageflip != nil && m.Pageflip.SubTitle != "" {
+		label = m.Pageflip.SubTitle
+	}
+	manifest := iiif.NewManifest(id, iiif.Text("none", label))
+	manifest.RequiredStatement = &iiif.MetadataEntry{
+		Label: iiif.Text("en", "Attribution"),
+		Value: iiif.Text("none", "G. Henle Verlag"),
+	}
+	metadata := []struct{ label, value string }{{"HN", fmt.Sprintf("%04d", m.HN)}}
+	if book == nil && m.Pageflip != nil && m.Pageflip.MainTitle != "" {
+		manifest.Summary = iiif.Text("none", m.Pageflip.MainTitle)
+		metadata = append(metadata, struct{ label, value string }{"Composer", m.Pageflip.MainTitle})
+	}
+	if book != nil {
+		manifest.Summary = iiif.Text("none", book.Composer)
+		metadata = append(metadata, []struct{ label, value string }{
+			{"Composer", book.Composer},
+			{"ISMN", book.ISMN},
+			{"Instrumentation", book.Instrumentation},
+		}...)
+		if book.URL != "" {
+			manifest.Homepage = []iiif.Resource{{ID: book.URL, Type: "Text", Label: iiif.Text("none", label), Format: "text/html"}}
+		}
+	}
+	for _, entry := range metadata {
+		if entry.value != "" {
+			manifest.Metadata = append(manifest.Metadata, iiif.MetadataEntry{
+				Label: iiif.Text("en", entry.label),
+				Value: iiif.Text("none", entry.value),
+			})
+		}
+	}
+	for _, page := range m.PageNumbers() {
+		largest := m.PageVariants(page)[0]
+		manifest.Items = append(manifest.Items, iiif.ImageCanvas(
+			fmt.Sprintf("%s/canvas/%d", id, page),
+			iiif.Text("none", strconv.Itoa(page)),
+			IIIFImageURL(baseURL, m.HN, page),
+			largest.Width,
+			largest.Height,
+		))
+	}
+	return manifest
+}
+
+// RebaseIIIFManifest returns data, the IIIF manifest of the book hn written by WriteIIIFManifests,
+// with the ids under baseURL instead of the base URL it was written with.
+// The server rebases the manifests on the URL it is reached at, as it does the image services.
+func RebaseIIIFManifest(data []byte, hn int, baseURL string) ([]byte, error) {
+	var manifest struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	suffix := IIIFManifestURL("", hn)
+	if !strings.HasSuffix(manifest.ID, suffix) {
+		return nil, fmt.Errorf("manifest id %q is not that of HN %04d", manifest.ID, hn)
+	}
+	written := strings.TrimSuffix(manifest.ID, suffix) + "/iiif/henle/"
+	return bytes.ReplaceAll(data, []byte(`"`+written), []byte(`"`+strings.TrimSuffix(baseURL, "/")+"/iiif/henle/")), nil
+}
+
+// IIIFManifestFile returns the IIIF manifest file of the book hn under outDir.
+func IIIFManifestFile(outDir string, hn int) string {
+	return filepath.Join(BookDir(outDir, hn), "iiif-manifest.json")
+}
+
+// WriteIIIFManifests writes the IIIF manifest of every book hns, or of every downloaded book if empty,
+// to outDir/henle/<HN>/iiif-manifest.json, with the ids under baseURL, see ImageManifest.IIIFManifest.
+// books, which may be nil, holds the texts of the books. Books without a manifest.json are skipped.
+func WriteIIIFManifests(outDir string, baseURL string, hns []int, books Snapshot, stdout io.Writer) error {
+	if len(hns) == 0 {
+		var err error
+		if hns, err = DownloadedHNs(outDir); err != nil {
+			return err
+		}
+	}
+	for _, hn := range hns {
+		images, err := ReadImageManifest(manifestFile(outDir, fmt.Sprintf("%04d", hn)))
+		if err != nil {
+			fmt.Fprintf(stdout, "IIIF manifest of HN %04d error: %s\n", hn, err)
+			continue
+		}
+		if len(images.Pages) == 0 {
+			fmt.Fprintf(stdout, "Skipping HN %04d without manifest.json\n", hn)
+			continue
+		}
+		images.HN = hn
+		var book *Book
+		if b, ok := books[hn]; ok {
+			book = &b
+		}
+		data, err := json.MarshalIndent(images.IIIFManifest(baseURL, book), "", "  ")
+		if err != nil {
+			return err
+		}
+		filename := IIIFManifestFile(outDir, hn)
+		if err := os.WriteFile(filename, data, 0644); err != nil {
+			fmt.Fprintf(stdout, "IIIF manifest of HN %04d error: %s\n", hn, err)
+			continue
+		}
+		fmt.Fprintf(stdout, "IIIF manifest of HN %04d written to %s\n", hn, filename)
+	}
+	return nil
+}
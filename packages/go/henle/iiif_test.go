@@ -0,0 +1,50 @@
+package henle
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseIIIFImageID(t *testing.T) {
+	for _, test := range []struct {
+		hn, page int
+	}{{650, 1}, {1, 12}, {1410, 120}} {
+		id := IIIFImageID(test.hn, test.page)
+		hn, page, err := ParseIIIFImageID(id)
+		if err != nil {
+			t.Errorf("ParseIIIFImageID(%q) error: %s", id, err)
+		} else if hn != test.hn || page != test.page {
+			t.Errorf("ParseIIIFImageID(%q) = %d, %d, want %d, %d", id, hn, page, test.hn, test.page)
+		}
+	}
+
+	for _, id := range []string{"0650", "0650-", "-0001", "0650-0001-0002", "HN0650-0001", "0650-p1", ""} {
+		if hn, page, err := ParseIIIFImageID(id); err == nil {
+			t.Errorf("ParseIIIFImageID(%q) = %d, %d, want an error", id, hn, page)
+		}
+	}
+}
+
+func TestRebaseIIIFManifest(t *testing.T) {
+	m := ImageManifest{HN: 650, Pages: []PageImage{{Page: 1, Variant: "w500", File: "w500/0001.jpg", Width: 500, Height: 707}}}
+	data, err := json.Marshal(m.IIIFManifest("https://written.example/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebased, err := RebaseIIIFManifest(data, 650, "http://localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(rebased), "written.example") {
+		t.Errorf("the rebased manifest holds the base URL it was written with: %s", rebased)
+	}
+	want, _ := json.Marshal(m.IIIFManifest("http://localhost:8080", nil))
+	if string(rebased) != string(want) {
+		t.Errorf("got %s, want %s", rebased, want)
+	}
+
+	if _, err := RebaseIIIFManifest(data, 651, "http://localhost:8080"); err == nil {
+		t.Error("got no error rebasing the manifest of another book")
+	}
+}
@@ -1,17 +1,30 @@
 package henle
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/bluemonarch21/matchmaker/crawl"
+	"github.com/bluemonarch21/matchmaker/imaging"
 	"github.com/gocolly/colly"
 	"io"
 	"log"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 )
 
-func setupBookPagesCollectors(c *colly.Collector, c2 *colly.Collector, c3 *colly.Collector, outDir string, stdout io.Writer) {
+func setupBookPagesCollectors(c *colly.Collector, c2 *colly.Collector, c3 *colly.Collector, outDir string, opts CrawlOptions, report *crawl.Report, stdout io.Writer) *hnSet {
+	seen := newHNSet()
+	opts.track(c, "search")
+	opts.track(c2, "pageflip")
+	opts.track(c3, "image")
+	report.Track(c)
+	report.Track(c2)
+	report.Track(c3)
+
 	// Before making a request print "Visiting ..."
 	c.OnRequest(func(r *colly.Request) {
 		fmt.Fprintln(stdout, "c Visiting", r.URL.String())
@@ -24,66 +37,194 @@ func setupBookPagesCollectors(c *colly.Collector, c2 *colly.Collector, c3 *colly
 	})
 
 	// Let 2nd collector visit pageflip associated with HN numbers listed on a search page
-	c.OnHTML("article.result-item > div.result-column-left > div.result-content > div.short-facts-container > p.short-facts", func(e *colly.HTMLElement) {
-		parts := strings.Split(e.Text, "HN ")
-		hn, _ := strconv.Atoi(strings.TrimSpace(parts[len(parts)-1]))
-		fmt.Fprintf(stdout, "HN found: %04d\n", hn)
-		link := fmt.Sprintf("https://www.henle.de/en/detail/?pageflip=%04d", hn)
-		err := c2.Visit(link)
+	c.OnResponse(func(response *colly.Response) {
+		results, err := ParseSearchResults(bytes.NewReader(response.Body), response.Request.URL)
 		if err != nil {
-			fmt.Fprintf(stdout, "c2.Visiting %s error: %s", link, err)
+			fmt.Fprintf(stdout, "ParseSearchResults %s error: %s\n", response.Request.URL, err)
+			report.Error(err)
+			return
+		}
+		report.Selector("article.result-item", len(results) == 0)
+		for _, result := range results {
+			if result.HN == 0 {
+				fmt.Fprintf(stdout, "HN not found in %s\n", response.Request.URL)
+				continue
+			}
+			visitPageflip(c2, result.HN, seen, opts, stdout)
 		}
 	})
 
-	// Get numbers of pages of a book
+	// Get the pages of a book from the Pageflip.init payload
 	c2.OnHTML("script", func(e *colly.HTMLElement) {
-		//
-		//        $(window).load(function(){
-		//            Pageflip.init({"pages":0,"pagePath":"\/pageflip\/w500\/0650\/","pagePathZoom":"\/pageflip\/w1500\/0650\/","pageWidth":1000,"pageHeight":null,"pageIgnoreFirst":0,"paginationPrefix":"Page ","paginationFirst":"","paginationSecond":"","paginationNextToLast":"","paginationLast":"","goToPage":"Go to page\u2026","zoomExitHint":"Press ESC to exit Zoom","henleId":"0650","mainTitle":"Alb\u00e9niz, Isaac","subTitle":"Iberia \u00b7 Fourth Book","voices":[]});
-		//        });
-		//
-		for _, s := range strings.Split(e.Text, "{") {
-			if strings.HasPrefix(s, "\"pages\":") {
-				pages, err := strconv.Atoi(strings.TrimPrefix(strings.Split(s, ",")[0], "\"pages\":"))
+		if !strings.Contains(e.Text, "Pageflip.init") {
+			return
+		}
+		e.Request.Ctx.Put("pageflip", "found")
+		info, err := ParsePageflipScript(e.Text)
+		if err != nil {
+			fmt.Fprintf(stdout, "ParsePageflipScript %s error: %s\n", e.Request.URL, err)
+			report.Error(err)
+			return
+		}
+		if !info.Preview() {
+			// pages not available
+			e.Request.Ctx.Put("pageflip", "no preview")
+			report.Count("books without preview", 1)
+			return
+		}
+		report.Count("books", 1)
+		hn := e.Request.URL.Query().Get("pageflip")
+		manifest, err := ReadImageManifest(manifestFile(outDir, hn))
+		if err != nil {
+			fmt.Fprintf(stdout, "ReadImageManifest %s error: %s\n", hn, err)
+			report.Error(err)
+		}
+		manifest.HN, _ = strconv.Atoi(hn)
+		manifest.Pageflip = &info
+		manifest.Voices = nil
+		// the pages are requested with the context of the pageflip, to add them to its manifest
+		e.Request.Ctx.Put("manifest", &manifest)
+		bookDir := filepath.Join(outDir, "henle", hn)
+		requestPages := func(voice string, pageURLs func(*url.URL, string) ([]string, []int, error)) {
+			for _, width := range opts.Images.widths() {
+				links, pages, err := pageURLs(e.Request.URL, width)
 				if err != nil {
-					fmt.Fprintf(stdout, "c2.pages %s error: %s", e.Request.URL, err)
+					fmt.Fprintf(stdout, "HN %s error: %s\n", hn, err)
+					report.Error(fmt.Errorf("HN %s: %w", hn, err))
+					manifest.incomplete = true
+					continue
 				}
-				if pages == 0 {
-					// pages not available
-					return
-				} else {
-					for i := 1; i <= pages; i++ {
-						q := e.Request.URL.Query()
-						hn := q.Get("pageflip")
-						link := fmt.Sprintf("https://www.henle.de/pageflip/w1500/%s/%04d.jpg", hn, i)
-						err := c3.Visit(link)
-						if err != nil {
-							fmt.Fprintf(stdout, "c3.Visiting %s error: %s", link, err)
-						}
+				for i, link := range links {
+					file := path.Join(voice, width, fmt.Sprintf("%04d.jpg", pages[i]))
+					if page, ok := manifest.Page(file); ok && opts.Images.SkipExisting && page.Current(bookDir) {
+						report.Count("images skipped", 1)
+						continue
 					}
+					manifest.setTarget(link, PageImage{Page: pages[i], Voice: voice, Variant: width, File: file})
+					err := c3.Request("GET", link, nil, e.Request.Ctx, nil)
+					if err != nil {
+						fmt.Fprintf(stdout, "c3.Visiting %s error: %s", link, err)
+					}
+				}
+			}
+		}
+		if info.ScorePreview() {
+			requestPages("", info.PageURLs)
+		} else {
+			// only the voices are previewed
+			report.Count("books without score preview", 1)
+		}
+		for i, voice := range info.Voices {
+			if !voice.HasPages() {
+				// a name only, or a format not known yet
+				err := fmt.Errorf("HN %s: voice %d %s has no page path, its pages are not downloaded", hn, i+1, voice)
+				fmt.Fprintln(stdout, err)
+				report.Count("voices without pages", 1)
+				report.Error(err)
+				continue
+			}
+			dir := voice.Dir(i)
+			for _, v := range manifest.Voices {
+				if v.Dir == dir {
+					// voices of the same name, e.g. two "Violin" parts
+					dir = fmt.Sprintf("%s-%d", dir, i+1)
 				}
 			}
+			manifest.Voices = append(manifest.Voices, VoiceDir{voice.Name, dir})
+			requestPages(dir, voice.PageURLs)
 		}
 	})
 
-	// Saves returned book pages
+	c2.OnScraped(func(response *colly.Response) {
+		report.Selector("script Pageflip.init", response.Ctx.Get("pageflip") == "")
+		// the pages were visited synchronously while scraping
+		hn := response.Request.URL.Query().Get("pageflip")
+		complete := response.Ctx.Get("pageflip") == "no preview"
+		if manifest, ok := response.Ctx.GetAny("manifest").(*ImageManifest); ok {
+			complete = manifest.complete()
+			if err := manifest.WriteFile(manifestFile(outDir, hn)); err != nil {
+				fmt.Fprintf(stdout, "manifest %s error: %s\n", hn, err)
+				report.Error(err)
+				complete = false
+			}
+		}
+		if !complete {
+			// the pageflip is requested again on resume, the saved pages are kept in the manifest
+			fmt.Fprintf(stdout, "HN %s incomplete\n", hn)
+			report.Count("incomplete books", 1)
+			report.Error(fmt.Errorf("HN %s: not every page was saved", hn))
+			opts.requeue(response.Request.URL.String(), "pageflip")
+			return
+		}
+		n, _ := strconv.Atoi(hn)
+		opts.complete(n)
+	})
+
+	// Saves returned book pages and adds them to the manifest of their book
 	c3.OnResponse(func(response *colly.Response) {
-		elems := strings.Split(response.Request.URL.Path, "/")
-		hn := elems[len(elems)-2]
-		filename := elems[len(elems)-1]
-		dirPath := filepath.Join(outDir, fmt.Sprintf("henle/%s/w1500", hn))
-		err := os.MkdirAll(dirPath, 0666)
+		manifest, ok := response.Ctx.GetAny("manifest").(*ImageManifest)
+		if !ok {
+			return
+		}
+		page, ok := manifest.target(response.Request.URL.String())
+		if !ok {
+			err := fmt.Errorf("unexpected page %s", response.Request.URL)
+			fmt.Fprintln(stdout, err)
+			report.Error(err)
+			return
+		}
+		filename := filepath.Join(BookDir(outDir, manifest.HN), filepath.FromSlash(page.File))
+		err := os.MkdirAll(filepath.Dir(filename), 0755)
 		if err != nil {
-			fmt.Fprintf(stdout, "os.MkdirAll %s error: %s", dirPath, err)
+			fmt.Fprintf(stdout, "os.MkdirAll %s error: %s", filepath.Dir(filename), err)
 		}
-		err = response.Save(filepath.Join(dirPath, filename))
+		err = response.Save(filename)
 		if err != nil {
 			fmt.Fprintf(stdout, "c3.Save %s error: %s", response.Request.URL, err)
+			report.Error(err)
+			return
 		}
+		if page.Voice != "" {
+			report.Count("voice images", 1)
+		} else {
+			report.Count("images", 1)
+		}
+		info, err := imaging.Describe(response.Body)
+		if err != nil {
+			fmt.Fprintf(stdout, "imaging.Describe %s error: %s\n", response.Request.URL, err)
+			report.Error(err)
+		}
+		page.Width = info.Width
+		page.Height = info.Height
+		page.Size = int64(len(response.Body))
+		page.SHA256 = imaging.SHA256(response.Body)
+		manifest.Set(page)
+		manifest.saved(response.Request.URL.String())
 	})
+	return seen
 }
 
-func ScrapeBookImages(verbose int, outDir string) {
+// visitPageflip visits the pageflip of the book hn with c, unless it was seen before or completed.
+func visitPageflip(c *colly.Collector, hn int, seen *hnSet, opts CrawlOptions, stdout io.Writer) {
+	if !seen.add(hn) {
+		fmt.Fprintf(stdout, "Skipping HN %04d found by more than one query\n", hn)
+		return
+	}
+	if opts.completed(hn) {
+		fmt.Fprintf(stdout, "Skipping HN %04d completed before\n", hn)
+		return
+	}
+	fmt.Fprintf(stdout, "HN found: %04d\n", hn)
+	link := fmt.Sprintf("https://www.henle.de/en/detail/?pageflip=%04d", hn)
+	if err := c.Visit(link); err != nil {
+		fmt.Fprintf(stdout, "c2.Visiting %s error: %s", link, err)
+	}
+}
+
+// ScrapeBookImages crawls the search results of every query in opts, or the HNs of opts.Images,
+// and saves the pageflip preview pages of each book under outDir/henle/<HN>/<width>, and those of its voices
+// under outDir/henle/<HN>/<voice>/<width>, listed in outDir/henle/<HN>/manifest.json.
+func ScrapeBookImages(verbose int, outDir string, opts CrawlOptions) {
 	var verbout io.Writer
 	switch verbose {
 	case 0:
@@ -92,39 +233,53 @@ func ScrapeBookImages(verbose int, outDir string) {
 		verbout = os.Stdout
 	}
 
-	c := colly.NewCollector(
-		colly.AllowedDomains("www.henle.de"),
-		colly.CacheDir("../../cache"),
-	)
+	c, err := opts.newCollector()
+	if err != nil {
+		log.Fatal(err)
+	}
+	report := crawl.NewReport("henle images")
+	if err := opts.Politeness.Apply(c, 2, report); err != nil {
+		log.Fatal(err)
+	}
 	c2 := c.Clone()
 	c3 := c.Clone()
+	// the pages of an incomplete book are requested again when its pageflip is
+	c3.AllowURLRevisit = true
+	opts.Politeness.HandleRetries(c2, report)
+	opts.Politeness.HandleRetries(c3, report)
 
-	c2.Limit(&colly.LimitRule{
-		DomainGlob:  "*henle.*",
-		Parallelism: 1, // max collectors running
-		//Delay:       2 * time.Second,  // delay between each call. If collectors finish before delay, only parallelism=1.
-	})
-	c3.Limit(&colly.LimitRule{
-		DomainGlob:  "*henle.*",
-		Parallelism: 2, // max collectors running
-		//Delay:       2 * time.Second,  // delay between each call. If collectors finish before delay, only parallelism=1.
-	})
+	seen := setupBookPagesCollectors(c, c2, c3, outDir, opts, report, verbout)
+	queries := opts.queries()
+	stats := newSearchStats(queries)
+	setupSearchPagination(c, stats, opts.MaxPages, verbout)
 
-	setupBookPagesCollectors(c, c2, c3, outDir, verbout)
+	// Continue an interrupted crawl
+	for _, err := range opts.resume(map[string]*colly.Collector{"search": c, "pageflip": c2}) {
+		log.Println("resume error:", err)
+	}
 
 	// Start scraping on ...
-	// List View
-	err := c.Visit("https://www.henle.de/en/search/?Scoring=Keyboard+instruments&Instrument=Piano+solo")
+	if len(opts.Images.HNs) > 0 {
+		for _, hn := range opts.Images.HNs {
+			visitPageflip(c2, hn, seen, opts, verbout)
+		}
+	} else {
+		// List View
+		for _, err := range visitSearchPages(c, queries) {
+			log.Println("c.Visit error:", err)
+		}
+	}
 
 	// Detail View: Normal
 	// Visit("https://www.henle.de/en/detail/?Title=Suite+Espagnole+op.+47_783")
 	// Detail View: No preview available
 	// Visit("https://www.henle.de/en/detail/?Title=Iberia+%C2%B7+Fourth+Book_650")
 
-	if err != nil {
-		log.Println("c.Visit error:", err)
-	}
-
 	c2.Wait()
 	c3.Wait()
+	fmt.Println(stats)
+	report.Set("search", stats.Queries)
+	if err := report.WriteFile(opts.reportFile("henle-images-report.json")); err != nil {
+		log.Println("report.WriteFile error:", err)
+	}
 }
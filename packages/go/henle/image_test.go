@@ -0,0 +1,57 @@
+package henle
+
+import (
+	"github.com/bluemonarch21/matchmaker/crawl"
+	"github.com/gocolly/colly"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBookPagesVoicesOnly(t *testing.T) {
+	page := gradientPNG(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/en/detail/" {
+			http.ServeFile(w, r, filepath.Join("testdata", "pageflip-voices-only.html"))
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/pageflip/w1500/0008_violin/") {
+			w.Write(page)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	outDir := t.TempDir()
+	c := colly.NewCollector()
+	c2 := c.Clone()
+	c3 := c.Clone()
+	report := crawl.NewReport("test")
+	opts := CrawlOptions{Images: ImageOptions{Widths: []string{"w1500"}}}
+	setupBookPagesCollectors(c, c2, c3, outDir, opts, report, io.Discard)
+	if err := c2.Visit(server.URL + "/en/detail/?pageflip=0008"); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := ReadImageManifest(manifestFile(outDir, "0008"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, p := range manifest.Pages {
+		files = append(files, p.File)
+	}
+	if want := []string{"violin/w1500/0001.jpg", "violin/w1500/0002.jpg"}; strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Errorf("got pages %v, want %v", files, want)
+	}
+	if report.Items["books without preview"] != 0 || report.Items["incomplete books"] != 0 {
+		t.Errorf("got items %v, want the book complete", report.Items)
+	}
+	if report.Items["voice images"] != 2 {
+		t.Errorf("got %d voice images, want 2", report.Items["voice images"])
+	}
+}
@@ -0,0 +1,255 @@
+package henle
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Snapshot is the set of books of one crawl, keyed by HN.
+// Books whose HN could not be parsed are left out, they cannot be told apart.
+type Snapshot map[int]Book
+
+// LoadSnapshotFile reads the books written by a JSONSink.
+func LoadSnapshotFile(filename string) (Snapshot, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	snapshot := make(Snapshot)
+	dec := json.NewDecoder(file)
+	for {
+		var book Book
+		if err := dec.Decode(&book); err == io.EOF {
+			return snapshot, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if book.HN != 0 {
+			snapshot[book.HN] = book
+		}
+	}
+}
+
+// LoadSnapshotMongo reads the books written by a MongoSink.
+func LoadSnapshotMongo(collection *mongo.Collection) (Snapshot, error) {
+	cur, err := collection.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+	snapshot := make(Snapshot)
+	for cur.Next(context.Background()) {
+		var book Book
+		if err := cur.Decode(&book); err != nil {
+			return nil, err
+		}
+		if book.HN != 0 {
+			snapshot[book.HN] = book
+		}
+	}
+	return snapshot, cur.Err()
+}
+
+// Kinds of Change.
+const (
+	Added    = "added"
+	Removed  = "removed"
+	Modified = "modified"
+	// Previous is the kind of the first BookVersion of a history started from a snapshot book.
+	Previous = "previous"
+)
+
+// Change describes how a book changed since the previous crawl.
+type Change struct {
+	HN     int
+	Kind   string // Added, Removed or Modified
+	Time   time.Time
+	Fields []FieldChange // only set for Modified
+}
+
+// FieldChange is one modified field of a book, e.g. "Price" or "Sections[0].Pieces[2].Difficulties[0].Min".
+type FieldChange struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// volatileFields change between crawls without the book changing, they are left out of DiffBooks.
+var volatileFields = map[string]bool{
+	"Cover.ETag":         true,
+	"Cover.LastModified": true,
+	"Cover.DHash":        true,
+}
+
+// DiffBooks compares two versions of a book field by field.
+// Structs, pointers and slices of the same length are compared element by element.
+// The validators and perceptual hash of the cover are not compared.
+func DiffBooks(old Book, new Book) []FieldChange {
+	var changes []FieldChange
+	diffValues("", reflect.ValueOf(old), reflect.ValueOf(new), &changes)
+	return changes
+}
+
+func diffValues(path string, a reflect.Value, b reflect.Value, changes *[]FieldChange) {
+	if volatileFields[path] {
+		return
+	}
+	switch a.Kind() {
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			name := a.Type().Field(i).Name
+			if path != "" {
+				name = path + "." + name
+			}
+			diffValues(name, a.Field(i), b.Field(i), changes)
+		}
+		return
+	case reflect.Ptr:
+		if !a.IsNil() && !b.IsNil() {
+			diffValues(path, a.Elem(), b.Elem(), changes)
+			return
+		}
+	case reflect.Slice:
+		if a.Len() == b.Len() && a.Len() > 0 {
+			for i := 0; i < a.Len(); i++ {
+				diffValues(fmt.Sprintf("%s[%d]", path, i), a.Index(i), b.Index(i), changes)
+			}
+			return
+		}
+		if a.Len() == 0 && b.Len() == 0 {
+			// nil and empty slices are the same after a JSON round trip
+			return
+		}
+	case reflect.Map:
+		if a.Len() == 0 && b.Len() == 0 {
+			return
+		}
+	}
+	if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+		*changes = append(*changes, FieldChange{path, a.Interface(), b.Interface()})
+	}
+}
+
+// BookVersion is one line of the history file of a book.
+type BookVersion struct {
+	Version int
+	Time    time.Time
+	Kind    string // Added, Removed, Modified or Previous
+	Book    *Book  // nil when removed
+}
+
+// IncrementalSink compares every written book against the previous snapshot by HN.
+// Each Change is written to the change log as one JSON object per line, and every new
+// version of a book is appended to <historyDir>/<HN>.jsonl.
+// Books of the snapshot that were not written are recorded as Removed on Close,
+// so the sink should only be used for full crawls. Books without HN are ignored.
+type IncrementalSink struct {
+	previous   Snapshot
+	seen       map[int]bool
+	changelog  *json.Encoder
+	historyDir string
+	// Time is recorded in every change, defaults to the time the sink was created.
+	Time time.Time
+}
+
+// NewIncrementalSink returns an IncrementalSink comparing against previous.
+// Closing the sink does not close changelog.
+func NewIncrementalSink(previous Snapshot, changelog io.Writer, historyDir string) *IncrementalSink {
+	return &IncrementalSink{
+		previous:   previous,
+		seen:       make(map[int]bool),
+		changelog:  json.NewEncoder(changelog),
+		historyDir: historyDir,
+		Time:       time.Now(),
+	}
+}
+
+func (s *IncrementalSink) Write(book Book) error {
+	if book.HN == 0 {
+		return nil
+	}
+	s.seen[book.HN] = true
+	old, ok := s.previous[book.HN]
+	if !ok {
+		return s.record(Change{HN: book.HN, Kind: Added, Time: s.Time}, &book)
+	}
+	fields := DiffBooks(old, book)
+	if len(fields) == 0 {
+		return nil
+	}
+	return s.record(Change{HN: book.HN, Kind: Modified, Time: s.Time, Fields: fields}, &book)
+}
+
+func (s *IncrementalSink) Close() error {
+	var removed []int
+	for hn := range s.previous {
+		if !s.seen[hn] {
+			removed = append(removed, hn)
+		}
+	}
+	sort.Ints(removed)
+	for _, hn := range removed {
+		if err := s.record(Change{HN: hn, Kind: Removed, Time: s.Time}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record writes change to the change log and appends book as a new version to the history of its HN.
+// A history that does not exist yet is started with the book of the previous snapshot.
+func (s *IncrementalSink) record(change Change, book *Book) error {
+	if err := s.changelog.Encode(change); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.historyDir, 0755); err != nil {
+		return err
+	}
+	filename := filepath.Join(s.historyDir, fmt.Sprintf("%04d.jsonl", change.HN))
+	version, err := countLines(filename)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	enc := json.NewEncoder(file)
+	if old, ok := s.previous[change.HN]; ok && version == 0 {
+		version++
+		if err := enc.Encode(BookVersion{version, time.Time{}, Previous, &old}); err != nil {
+			return err
+		}
+	}
+	return enc.Encode(BookVersion{version + 1, change.Time, change.Kind, book})
+}
+
+// countLines returns the number of lines of a file, 0 if it does not exist.
+func countLines(filename string) (int, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	n := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		n++
+	}
+	return n, scanner.Err()
+}
@@ -0,0 +1,46 @@
+package henle
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestDiffBooksIgnoresCoverValidators(t *testing.T) {
+	old := Book{HN: 1223, Cover: &CoverImage{File: "henle/1223/cover.jpg", ETag: `"a"`, LastModified: "Mon, 01 Mar 2021 10:00:00 GMT", DHash: "00ff"}}
+	new := Book{HN: 1223, Cover: &CoverImage{File: "henle/1223/cover.jpg", ETag: `"b"`, LastModified: "Tue, 02 Mar 2021 10:00:00 GMT"}}
+	if changes := DiffBooks(old, new); len(changes) != 0 {
+		t.Errorf("got changes %+v, want none", changes)
+	}
+	new.Cover.File = "henle/1223/cover.png"
+	if changes := DiffBooks(old, new); len(changes) != 1 || changes[0].Field != "Cover.File" {
+		t.Errorf("got changes %+v, want Cover.File", changes)
+	}
+}
+
+func TestIncrementalSinkIgnoresBooksWithoutHN(t *testing.T) {
+	previous := Snapshot{185: {HN: 185, Title: "Nocturnes"}}
+	var changelog bytes.Buffer
+	sink := NewIncrementalSink(previous, &changelog, t.TempDir())
+	books := []Book{
+		{HN: 185, Title: "Nocturnes"},
+		{URL: "https://www.henle.de/en/detail/?Title=Etudes", Title: "Etudes"},
+		{URL: "https://www.henle.de/en/detail/?Title=Preludes", Title: "Preludes"},
+	}
+	for _, book := range books {
+		if err := sink.Write(book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(&changelog)
+	for dec.More() {
+		var change Change
+		if err := dec.Decode(&change); err != nil {
+			t.Fatal(err)
+		}
+		t.Errorf("got change %+v, want none", change)
+	}
+}
@@ -0,0 +1,111 @@
+package henle
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gocolly/colly"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Localization holds the texts of a book detail page in one language.
+type Localization struct {
+	URL             string
+	Title           string
+	Description     string
+	Instrumentation string
+	DetailTitles    []string // titles of the pieces and movements in page order, see Book.Details
+}
+
+func localizationOf(book Book) Localization {
+	details := book.Details()
+	titles := make([]string, len(details))
+	for i, detail := range details {
+		titles[i] = detail.Title
+	}
+	return Localization{
+		URL:             book.URL,
+		Title:           book.Title,
+		Description:     book.Description,
+		Instrumentation: book.Instrumentation,
+		DetailTitles:    titles,
+	}
+}
+
+// Localize adds the texts of other, the same book scraped from a page in language lang, to b.Localized.
+// The texts of b itself are added under the language of its URL if not there yet.
+func (b *Book) Localize(lang string, other Book) error {
+	if other.HN != b.HN {
+		return fmt.Errorf("HN %04d of %s does not match HN %04d of %s", other.HN, other.URL, b.HN, b.URL)
+	}
+	if b.Localized == nil {
+		b.Localized = make(map[string]Localization)
+	}
+	if u, err := url.Parse(b.URL); err == nil {
+		if own := pageLanguage(u); own != "" {
+			if _, ok := b.Localized[own]; !ok {
+				b.Localized[own] = localizationOf(*b)
+			}
+		}
+	}
+	b.Localized[lang] = localizationOf(other)
+	return nil
+}
+
+// pageLanguage returns the language path of a henle.de URL, e.g. "en" for https://www.henle.de/en/detail/.
+func pageLanguage(u *url.URL) string {
+	return strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)[0]
+}
+
+// localizedURL returns pageURL with its language path replaced by lang.
+func localizedURL(pageURL *url.URL, lang string) string {
+	u := *pageURL
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	parts[0] = lang
+	u.Path = "/" + strings.Join(parts, "/")
+	return u.String()
+}
+
+// setupLocalizedCollector makes c parse the detail pages it visits into the "book" value of the request context.
+// c must not be asynchronous, so the parsed book is available as soon as the request returns.
+func setupLocalizedCollector(c *colly.Collector, stdout io.Writer) {
+	c.OnRequest(func(r *colly.Request) {
+		fmt.Fprintln(stdout, "c3 Visiting", r.URL.String())
+	})
+	c.OnResponse(func(response *colly.Response) {
+		book, err := ParseBookDetail(bytes.NewReader(response.Body), response.Request.URL)
+		if err != nil {
+			fmt.Fprintf(stdout, "ParseBookDetail %s error: %s\n", response.Request.URL, err)
+			return
+		}
+		response.Ctx.Put("book", book)
+	})
+}
+
+// localizeBook visits the detail page of book in each of langs with c and merges the localized texts into book.
+// c must have been set up with setupLocalizedCollector.
+func localizeBook(c *colly.Collector, book *Book, langs []string, stdout io.Writer) {
+	pageURL, err := url.Parse(book.URL)
+	if err != nil {
+		return
+	}
+	for _, lang := range langs {
+		if lang == pageLanguage(pageURL) {
+			continue
+		}
+		link := localizedURL(pageURL, lang)
+		ctx := colly.NewContext()
+		if err := c.Request("GET", link, nil, ctx, nil); err != nil {
+			fmt.Fprintf(stdout, "c3.Visiting %s error: %s\n", link, err)
+			continue
+		}
+		other, ok := ctx.GetAny("book").(Book)
+		if !ok {
+			continue
+		}
+		if err := book.Localize(lang, other); err != nil {
+			book.addFieldError("Localized."+lang, err)
+		}
+	}
+}
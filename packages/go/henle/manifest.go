@@ -0,0 +1,188 @@
+package henle
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bluemonarch21/matchmaker/imaging"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ImageOptions configures which pageflip preview pages ScrapeBookImages downloads.
+type ImageOptions struct {
+	// Widths are the width variants of the pages to download, e.g. "w500" and "w1500".
+	// Defaults to DefaultPageWidths.
+	Widths []string
+	// HNs are the books to download instead of those found by the search queries.
+	HNs []int
+	// SkipExisting skips the pages listed in the manifest of their book whose file
+	// still has the size and hash recorded there.
+	SkipExisting bool
+}
+
+// DefaultPageWidths is the width variant downloaded when ImageOptions.Widths is empty.
+var DefaultPageWidths = []string{"w1500"}
+
+func (o ImageOptions) widths() []string {
+	if len(o.Widths) == 0 {
+		return DefaultPageWidths
+	}
+	return o.Widths
+}
+
+// ParseHNs parses a comma separated list of HNs and ranges of HNs, e.g. "650,1223,1400-1410".
+func ParseHNs(s string) ([]int, error) {
+	var hns []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid HN %q", part)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil || last < first {
+				return nil, fmt.Errorf("invalid HN range %q", part)
+			}
+		}
+		for hn := first; hn <= last; hn++ {
+			hns = append(hns, hn)
+		}
+	}
+	return hns, nil
+}
+
+// ImageManifest lists the downloaded preview pages of a book.
+// It is written as manifest.json in the directory of the book, henle/<HN>.
+type ImageManifest struct {
+	HN       int
+	Pageflip *PageflipInfo // payload of the pageflip page the pages were downloaded from
+	Voices   []VoiceDir    // separately previewed parts of the book, in the order of the pageflip
+	Pages    []PageImage   // by voice directory, the score first, then width variant, then page number
+
+	targets    map[string]PageImage // pages being downloaded by URL, without the image fields
+	incomplete bool                 // pages could not be listed
+}
+
+// VoiceDir is a voice of the pageflip with the directory its pages are saved in, e.g. "violin".
+type VoiceDir struct {
+	Name string
+	Dir  string
+}
+
+// PageImage is a downloaded preview page.
+type PageImage struct {
+	Page    int    // page number in the pageflip, starting at 1
+	Voice   string // directory of the voice, "" for the score
+	Variant string // width variant, e.g. "w1500"
+	File    string // path relative to the directory of the book, e.g. "w1500/0001.jpg" or "violin/w1500/0001.jpg"
+	Width   int
+	Height  int
+	Size    int64
+	SHA256  string // hex encoded hash of File
+	DHash   string // perceptual hash of File, see HashBookImages, "" until computed
+}
+
+// BookDir returns the directory the pages of the book hn are saved in under outDir, henle/<HN>.
+func BookDir(outDir string, hn int) string {
+	return filepath.Join(outDir, "henle", fmt.Sprintf("%04d", hn))
+}
+
+// manifestFile returns the manifest.json of the book hn under outDir.
+func manifestFile(outDir string, hn string) string {
+	return filepath.Join(outDir, "henle", hn, "manifest.json")
+}
+
+// ReadImageManifest reads a manifest.json. A missing file is an empty manifest.
+func ReadImageManifest(filename string) (ImageManifest, error) {
+	var m ImageManifest
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+// WriteFile writes the manifest as indented JSON.
+func (m ImageManifest) WriteFile(filename string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// Page returns the page of the manifest stored in file, and whether there is one.
+func (m ImageManifest) Page(file string) (PageImage, bool) {
+	for _, p := range m.Pages {
+		if p.File == file {
+			return p, true
+		}
+	}
+	return PageImage{}, false
+}
+
+// Set adds page to the manifest, replacing the page stored in the same file.
+func (m *ImageManifest) Set(page PageImage) {
+	for i, p := range m.Pages {
+		if p.File == page.File {
+			m.Pages[i] = page
+			return
+		}
+	}
+	m.Pages = append(m.Pages, page)
+	sort.SliceStable(m.Pages, func(i, j int) bool {
+		if m.Pages[i].Voice != m.Pages[j].Voice {
+			return m.Pages[i].Voice < m.Pages[j].Voice
+		}
+		if m.Pages[i].Variant != m.Pages[j].Variant {
+			return m.Pages[i].Variant < m.Pages[j].Variant
+		}
+		return m.Pages[i].Page < m.Pages[j].Page
+	})
+}
+
+// setTarget records the page downloaded from link, see target.
+func (m *ImageManifest) setTarget(link string, page PageImage) {
+	if m.targets == nil {
+		m.targets = make(map[string]PageImage)
+	}
+	m.targets[link] = page
+}
+
+// target returns the page downloaded from link, without its image fields, and whether there is one.
+func (m *ImageManifest) target(link string) (PageImage, bool) {
+	page, ok := m.targets[link]
+	return page, ok
+}
+
+// saved removes the page downloaded from link from the targets, see complete.
+func (m *ImageManifest) saved(link string) {
+	delete(m.targets, link)
+}
+
+// complete tells whether every page listed for download was saved.
+func (m *ImageManifest) complete() bool {
+	return len(m.targets) == 0 && !m.incomplete
+}
+
+// Current tells whether the file of page, under the directory of its book, still has the size and hash of page.
+func (p PageImage) Current(bookDir string) bool {
+	filename := filepath.Join(bookDir, filepath.FromSlash(p.File))
+	info, err := os.Stat(filename)
+	if err != nil || info.Size() != p.Size {
+		return false
+	}
+	hash, err := imaging.FileSHA256(filename)
+	return err == nil && hash == p.SHA256
+}
@@ -0,0 +1,84 @@
+package henle
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bluemonarch21/matchmaker/imaging"
+	"github.com/bluemonarch21/matchmaker/staff"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// PageNotation holds the staff systems found on a downloaded preview page, see staff.Detect.
+// It is written as one line of JSON per page by WriteNotationFeatures.
+type PageNotation struct {
+	HN    int
+	Voice string // directory of the voice, "" for the score
+	Page  int
+	File  string // path relative to the directory of the book, e.g. "w1500/0001.jpg"
+	staff.Analysis
+}
+
+// BookNotation returns the staff systems of the pages of one width variant of the book hn downloaded under outDir,
+// those of the score first, then those of each voice, in page order.
+// Pages that cannot be read or decoded are written to stdout and left out.
+func BookNotation(outDir string, hn int, width string, stdout io.Writer) ([]PageNotation, error) {
+	bookDir := BookDir(outDir, hn)
+	manifest, err := ReadImageManifest(filepath.Join(bookDir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	var pages []PageNotation
+	for _, page := range manifest.Pages {
+		if page.Variant != width {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(bookDir, filepath.FromSlash(page.File)))
+		if err != nil {
+			fmt.Fprintf(stdout, "Notation of HN %04d page %s error: %s\n", hn, page.File, err)
+			continue
+		}
+		img, _, err := imaging.Decode(data)
+		if err != nil {
+			fmt.Fprintf(stdout, "Notation of HN %04d page %s error: %s\n", hn, page.File, err)
+			continue
+		}
+		pages = append(pages, PageNotation{
+			HN:       hn,
+			Voice:    page.Voice,
+			Page:     page.Page,
+			File:     page.File,
+			Analysis: staff.Detect(img),
+		})
+	}
+	return pages, nil
+}
+
+// WriteNotationFeatures writes the staff systems of the pages of one width variant of every book hns,
+// or of every downloaded book if empty, to w as JSON lines, see BookNotation.
+func WriteNotationFeatures(outDir string, width string, hns []int, w io.Writer, stdout io.Writer) error {
+	if len(hns) == 0 {
+		var err error
+		if hns, err = DownloadedHNs(outDir); err != nil {
+			return err
+		}
+	}
+	enc := json.NewEncoder(w)
+	for _, hn := range hns {
+		pages, err := BookNotation(outDir, hn, width, stdout)
+		if err != nil {
+			fmt.Fprintf(stdout, "Notation of HN %04d error: %s\n", hn, err)
+			continue
+		}
+		systems := 0
+		for _, page := range pages {
+			if err := enc.Encode(page); err != nil {
+				return err
+			}
+			systems += len(page.Systems)
+		}
+		fmt.Fprintf(stdout, "HN %04d: %d systems on %d pages\n", hn, systems, len(pages))
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package henle
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBookNotationSkipsBadPages(t *testing.T) {
+	outDir := writeBadPageBook(t)
+	var stdout bytes.Buffer
+	pages, err := BookNotation(outDir, 650, "w1500", &stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || pages[0].Page != 1 || pages[0].Width != 64 {
+		t.Errorf("got pages %+v, want the analysis of page 1", pages)
+	}
+	for _, file := range []string{"w1500/0002.jpg", "w1500/0003.jpg"} {
+		if !strings.Contains(stdout.String(), file) {
+			t.Errorf("the bad page %s is not reported, output: %q", file, stdout.String())
+		}
+	}
+
+	var jsonl bytes.Buffer
+	stdout.Reset()
+	if err := WriteNotationFeatures(outDir, "w1500", []int{650}, &jsonl, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(jsonl.String(), "\n"); lines != 1 {
+		t.Errorf("got %d JSON lines, want the one of page 1", lines)
+	}
+}
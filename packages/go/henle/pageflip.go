@@ -0,0 +1,244 @@
+package henle
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PageflipInfo is the payload of the Pageflip.init call on a pageflip page, e.g.
+//
+//	Pageflip.init({"pages":12,"pagePath":"\/pageflip\/w500\/0650\/","pagePathZoom":"\/pageflip\/w1500\/0650\/","pageWidth":1000,"pageHeight":null,"pageIgnoreFirst":0,...,"henleId":"0650","mainTitle":"Albéniz, Isaac","subTitle":"Iberia · Fourth Book","voices":[]});
+//
+// The texts of the viewer's controls are left out.
+type PageflipInfo struct {
+	Pages           int    // number of page images, 0 if there is no preview
+	PagePath        string // directory of the page images, e.g. "/pageflip/w500/0650/"
+	PagePathZoom    string // directory of the zoomed page images, e.g. "/pageflip/w1500/0650/"
+	PageWidth       int    // 0 if null
+	PageHeight      int    // 0 if null
+	PageIgnoreFirst int    // number of leading page images the viewer does not show
+	HenleID         string // zero padded HN, e.g. "0650"
+	MainTitle       string // usually the composer, e.g. "Albéniz, Isaac"
+	SubTitle        string // usually the title, e.g. "Iberia · Fourth Book"
+	Voices          []PageflipVoice
+}
+
+// PageflipVoice is a part of a chamber edition with its own preview, e.g. the violin part of a violin sonata.
+// The voices are expected either as names or as objects with the fields of PageflipInfo,
+// no pageflip with voices has been seen yet. Only voices with a page path can be downloaded, see HasPages.
+type PageflipVoice struct {
+	Name            string
+	Pages           int
+	PagePath        string
+	PagePathZoom    string
+	PageIgnoreFirst int
+	// Raw is the payload of a voice in none of the expected formats.
+	Raw json.RawMessage `json:",omitempty"`
+}
+
+func (v *PageflipVoice) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &v.Name); err == nil {
+		return nil
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		*v = PageflipVoice{Raw: append(json.RawMessage(nil), data...)}
+		return nil
+	}
+	var fields struct {
+		Name            string `json:"name"`
+		Title           string `json:"title"`
+		Label           string `json:"label"`
+		Voice           string `json:"voice"`
+		Pages           int    `json:"pages"`
+		PagePath        string `json:"pagePath"`
+		PagePathZoom    string `json:"pagePathZoom"`
+		Path            string `json:"path"`
+		PathZoom        string `json:"pathZoom"`
+		PageIgnoreFirst int    `json:"pageIgnoreFirst"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*v = PageflipVoice{
+		Name:            firstNonEmpty(fields.Name, fields.Title, fields.Label, fields.Voice),
+		Pages:           fields.Pages,
+		PagePath:        firstNonEmpty(fields.PagePath, fields.Path),
+		PagePathZoom:    firstNonEmpty(fields.PagePathZoom, fields.PathZoom),
+		PageIgnoreFirst: fields.PageIgnoreFirst,
+	}
+	if v.Name == "" && !v.HasPages() {
+		v.Raw = append(json.RawMessage(nil), data...)
+	}
+	return nil
+}
+
+// HasPages tells whether the pages of the voice can be downloaded.
+func (v PageflipVoice) HasPages() bool {
+	return v.PagePath != "" || v.PagePathZoom != ""
+}
+
+// String returns the quoted name of the voice, or its payload if it has none.
+func (v PageflipVoice) String() string {
+	if v.Name == "" && len(v.Raw) > 0 {
+		return string(v.Raw)
+	}
+	return strconv.Quote(v.Name)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// ErrNoPageflip is returned when a page does not call Pageflip.init.
+var ErrNoPageflip = errors.New("Pageflip.init not found")
+
+// ParsePageflip parses the Pageflip.init payload of a pageflip page, e.g. https://www.henle.de/en/detail/?pageflip=0650.
+func ParsePageflip(r io.Reader) (PageflipInfo, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return PageflipInfo{}, err
+	}
+	return pageflipOf(doc.Selection)
+}
+
+// pageflipOf parses the first script of doc calling Pageflip.init.
+func pageflipOf(doc *goquery.Selection) (PageflipInfo, error) {
+	var info PageflipInfo
+	err := ErrNoPageflip
+	doc.Find("script").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if !strings.Contains(s.Text(), "Pageflip.init") {
+			return true
+		}
+		info, err = ParsePageflipScript(s.Text())
+		return false
+	})
+	return info, err
+}
+
+// ParsePageflipScript parses the payload of the Pageflip.init call in the text of a script element.
+func ParsePageflipScript(script string) (PageflipInfo, error) {
+	var info PageflipInfo
+	i := strings.Index(script, "Pageflip.init(")
+	if i < 0 {
+		return info, ErrNoPageflip
+	}
+	// the decoder stops after the object, before the closing parenthesis
+	dec := json.NewDecoder(strings.NewReader(script[i+len("Pageflip.init("):]))
+	if err := dec.Decode(&info); err != nil {
+		return info, fmt.Errorf("Pageflip.init: %w", err)
+	}
+	return info, nil
+}
+
+var widthSegment = regexp.MustCompile(`^w\d+$`)
+
+// pathWidth returns the width variant of a page directory, e.g. "w500" of "/pageflip/w500/0650/", or "".
+func pathWidth(dir string) string {
+	for _, segment := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if widthSegment.MatchString(segment) {
+			return segment
+		}
+	}
+	return ""
+}
+
+// pageDirs returns the page directories of a preview keyed by their width variant.
+func pageDirs(pagePath string, pagePathZoom string) map[string]string {
+	dirs := make(map[string]string)
+	for _, dir := range []string{pagePath, pagePathZoom} {
+		if width := pathWidth(dir); width != "" {
+			dirs[width] = dir
+		}
+	}
+	return dirs
+}
+
+// Widths returns the width variants of the pages, e.g. "w500" of PagePath and "w1500" of PagePathZoom.
+func (p PageflipInfo) Widths() []string {
+	var widths []string
+	for _, dir := range []string{p.PagePath, p.PagePathZoom} {
+		if width := pathWidth(dir); width != "" {
+			widths = append(widths, width)
+		}
+	}
+	return widths
+}
+
+// ScorePreview tells whether the viewer shows pages of the score.
+func (p PageflipInfo) ScorePreview() bool {
+	return p.Pages > p.PageIgnoreFirst
+}
+
+// Preview tells whether the viewer shows pages of the score or of one of the voices.
+// A chamber edition may preview its parts only.
+func (p PageflipInfo) Preview() bool {
+	if p.ScorePreview() {
+		return true
+	}
+	for _, v := range p.Voices {
+		if v.HasPages() && v.Pages > v.PageIgnoreFirst {
+			return true
+		}
+	}
+	return false
+}
+
+// PageURLs returns the URLs of the page images of one width variant shown by the viewer,
+// resolved against the URL of the pageflip page, and the page number of each.
+// The first PageIgnoreFirst images are left out.
+func (p PageflipInfo) PageURLs(pageURL *url.URL, width string) ([]string, []int, error) {
+	return pageURLs(pageURL, pageDirs(p.PagePath, p.PagePathZoom), width, p.Pages, p.PageIgnoreFirst)
+}
+
+// PageURLs returns the URLs of the page images of one width variant of the voice, see PageflipInfo.PageURLs.
+func (v PageflipVoice) PageURLs(pageURL *url.URL, width string) ([]string, []int, error) {
+	if !v.HasPages() {
+		return nil, nil, fmt.Errorf("voice %s has no page path", v)
+	}
+	return pageURLs(pageURL, pageDirs(v.PagePath, v.PagePathZoom), width, v.Pages, v.PageIgnoreFirst)
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// Dir returns the directory the pages of the i-th voice are saved in, its name in lower case
+// with runs of other characters than letters and digits replaced by "-", e.g. "violin-ii" of "Violin II".
+func (v PageflipVoice) Dir(i int) string {
+	dir := strings.Trim(nonAlphanumeric.ReplaceAllString(strings.ToLower(v.Name), "-"), "-")
+	if dir == "" || widthSegment.MatchString(dir) {
+		// not to be taken for a width variant of the score
+		dir = fmt.Sprintf("voice-%d", i+1)
+	}
+	return dir
+}
+
+func pageURLs(pageURL *url.URL, dirs map[string]string, width string, pages int, ignoreFirst int) ([]string, []int, error) {
+	dir, ok := dirs[width]
+	if !ok {
+		return nil, nil, fmt.Errorf("no %s pages in the pageflip", width)
+	}
+	var links []string
+	var numbers []int
+	for i := ignoreFirst + 1; i <= pages; i++ {
+		ref, err := url.Parse(path.Join(dir, fmt.Sprintf("%04d.jpg", i)))
+		if err != nil {
+			return nil, nil, err
+		}
+		links = append(links, pageURL.ResolveReference(ref).String())
+		numbers = append(numbers, i)
+	}
+	return links, numbers, nil
+}
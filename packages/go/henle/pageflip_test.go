@@ -0,0 +1,93 @@
+package henle
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPageflipVoices(t *testing.T) {
+	file, err := os.Open(filepath.Join("testdata", "pageflip-voices.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := ParsePageflip(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Voices) != 4 {
+		t.Fatalf("got %d voices, want 4", len(info.Voices))
+	}
+	pageURL, _ := url.Parse("https://www.henle.de/en/detail/?pageflip=0007")
+	violin, cello, piano, unknown := info.Voices[0], info.Voices[1], info.Voices[2], info.Voices[3]
+
+	links, pages, err := violin.PageURLs(pageURL, "w1500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLinks := []string{
+		"https://www.henle.de/pageflip/w1500/0007_violin/0001.jpg",
+		"https://www.henle.de/pageflip/w1500/0007_violin/0002.jpg",
+		"https://www.henle.de/pageflip/w1500/0007_violin/0003.jpg",
+	}
+	if !reflect.DeepEqual(links, wantLinks) || !reflect.DeepEqual(pages, []int{1, 2, 3}) {
+		t.Errorf("violin w1500: got %v %v, want %v", links, pages, wantLinks)
+	}
+	if dir := violin.Dir(0); dir != "violin" {
+		t.Errorf("violin Dir = %q", dir)
+	}
+
+	if !cello.HasPages() {
+		t.Error("violoncello given with path has no pages")
+	}
+	if _, _, err := cello.PageURLs(pageURL, "w1500"); err == nil {
+		t.Error("violoncello without pagePathZoom: got w1500 pages")
+	}
+
+	for _, voice := range []PageflipVoice{piano, unknown} {
+		if voice.HasPages() {
+			t.Errorf("voice %s: got pages", voice)
+		}
+		if _, _, err := voice.PageURLs(pageURL, "w1500"); err == nil {
+			t.Errorf("voice %s: got no error listing its pages", voice)
+		}
+	}
+	if s := piano.String(); s != `"Piano"` {
+		t.Errorf("piano String() = %s", s)
+	}
+	if s := unknown.String(); s != `{"id":17}` {
+		t.Errorf("unknown voice String() = %s, want its payload", s)
+	}
+}
+
+func TestPageflipPreview(t *testing.T) {
+	tests := []struct {
+		name         string
+		scorePreview bool
+		preview      bool
+	}{
+		{"pageflip", false, false},
+		{"pageflip-voices", true, true},
+		{"pageflip-voices-only", false, true},
+	}
+	for _, test := range tests {
+		file, err := os.Open(filepath.Join("testdata", test.name+".html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := ParsePageflip(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.ScorePreview(); got != test.scorePreview {
+			t.Errorf("%s: ScorePreview() = %v, want %v", test.name, got, test.scorePreview)
+		}
+		if got := info.Preview(); got != test.preview {
+			t.Errorf("%s: Preview() = %v, want %v", test.name, got, test.preview)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package henle
+
+import (
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SearchResult is one item of a search results page.
+type SearchResult struct {
+	HN  int
+	URL string // detail page of the book
+}
+
+// ParseSearchResults parses a search results page, e.g. https://www.henle.de/en/search/?Scoring=Keyboard+instruments.
+// pageURL is used to resolve relative links. URL is empty for items without a detail page link.
+func ParseSearchResults(r io.Reader, pageURL *url.URL) ([]SearchResult, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return searchResults(doc.Selection, pageURL), nil
+}
+
+// searchResults returns the result items found in doc.
+func searchResults(doc *goquery.Selection, pageURL *url.URL) []SearchResult {
+	var results []SearchResult
+	doc.Find("article.result-item > div.result-column-left").Each(func(_ int, s *goquery.Selection) {
+		link := childAttr(s, "figure.result-cover > a", "href")
+		if link != "" {
+			link = absoluteURL(pageURL, link)
+		}
+		text := childText(s, "div.result-content > div.short-facts-container > p.short-facts")
+		parts := strings.Split(text, "HN ")
+		hn, _ := strconv.Atoi(strings.TrimSpace(parts[len(parts)-1]))
+		results = append(results, SearchResult{
+			HN:  hn,
+			URL: link,
+		})
+	})
+	return results
+}
+
+// ErrNoPageURL is returned by the parsers that need the URL of the page when it is nil.
+var ErrNoPageURL = errors.New("page URL is nil")
+
+// ParseBookDetail parses a book detail page, e.g. https://www.henle.de/en/detail/?Title=Allegro+barbaro_1400.
+// pageURL is used to resolve relative links and is recorded as the URL of the book, ErrNoPageURL is returned if it is nil.
+func ParseBookDetail(r io.Reader, pageURL *url.URL) (Book, error) {
+	if pageURL == nil {
+		return Book{}, ErrNoPageURL
+	}
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return Book{}, err
+	}
+	book := Book{URL: pageURL.String()}
+
+	// collect 'Book' information
+	hero := doc.Find("div.detail-hero").First()
+	book.Title = childText(hero, "h2.main-title")
+	book.Composer = childText(hero, "h2.sub-title")
+	if link := childAttr(hero, "h2.sub-title a", "href"); link != "" {
+		book.ComposerURL = absoluteURL(pageURL, link)
+		book.ComposerID = PersonID(book.ComposerURL)
+	}
+	book.Price = strings.Replace(hero.Find("div.column-cart > p.price").Contents().Not("br,span").Text(), " ", " ", 1)
+	book.Description = strings.Replace(childText(hero, "div.article-text"), "\n", "\\n", -1)
+	if cover := childAttr(hero, "figure.cover-container > a > img", "data-src"); cover != "" {
+		book.CoverLink = absoluteURL(pageURL, cover)
+	}
+	// the preview viewer, if the page embeds it
+	if info, err := pageflipOf(doc.Selection); err == nil {
+		book.Pageflip = &info
+	} else if err != ErrNoPageflip {
+		book.addFieldError("Pageflip", err)
+	}
+	var inst []string
+	hero.Find("ul.breadcrumb > li").Each(func(_ int, s *goquery.Selection) {
+		inst = append(inst, s.Text())
+	})
+	book.Instrumentation = strings.Join(inst, ">")
+
+	var bookInfos []string
+	hero.Find("div.short-facts > p").Each(func(_ int, s *goquery.Selection) {
+		text := s.Text()
+		if role := childText(s, "span.role"); role != "" {
+			link := childAttr(s, "a", "href")
+			if link != "" {
+				link = absoluteURL(pageURL, link)
+			}
+			book.Authors = append(book.Authors, Contributor{
+				Name:     strings.TrimSpace(strings.TrimSuffix(text, role)),
+				Role:     strings.Trim(role, "()"),
+				URL:      link,
+				PersonID: PersonID(link),
+			})
+			return
+		}
+		if text != "" {
+			bookInfos = append(bookInfos, text)
+		}
+		if strings.HasPrefix(text, "HN ") {
+			tmp := strings.Split(text, "·")
+			hn, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(tmp[0], "HN ")))
+			if err != nil {
+				book.addFieldError("HN", err)
+			}
+			book.HN = hn
+			if len(tmp) > 1 {
+				book.ISMN = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(tmp[1]), "ISMN"))
+			}
+		}
+	})
+	book.BookInfo = strings.Join(bookInfos, "\\n")
+
+	// collect 'Detail' difficulty information, as sections of pieces with their movements
+	doc.Find("div.article-contents").First().Find("ul").Each(func(i int, s *goquery.Selection) {
+		// skip table header
+		if i == 0 {
+			return
+		}
+		if title := childText(s, "li.column-title > strong"); title != "" {
+			book.Sections = append(book.Sections, Section{Title: title})
+			return
+		}
+		if len(book.Sections) == 0 {
+			// pieces listed before the first heading
+			book.Sections = append(book.Sections, Section{})
+		}
+		n := len(book.Sections) - 1
+		section := &book.Sections[n]
+		// movements are indented below their piece
+		if s.HasClass("sub-item") && len(section.Pieces) > 0 {
+			piece := &section.Pieces[len(section.Pieces)-1]
+			field := fmt.Sprintf("Sections[%d].Pieces[%d].Movements[%d]", n, len(section.Pieces)-1, len(piece.Movements))
+			piece.Movements = append(piece.Movements, book.parseDetail(s, section.Title, field))
+			return
+		}
+		field := fmt.Sprintf("Sections[%d].Pieces[%d]", n, len(section.Pieces))
+		section.Pieces = append(section.Pieces, book.parseDetail(s, section.Title, field))
+	})
+
+	book.checkMissing()
+	return book, nil
+}
+
+// parseDetail parses a row of the contents table listed under the given section.
+// Errors are recorded in b under field, the path of the detail in b.
+func (b *Book) parseDetail(s *goquery.Selection, section string, field string) Detail {
+	var title string
+	composer := childText(s, "li.column-title > em")
+	if composer != "" {
+		title = s.Find("li.column-title").Contents().Not("em").Text()
+	} else {
+		title = childText(s, "li.column-title")
+	}
+	cell := s.Find("li.column-difficulty")
+	detail := Detail{
+		Title:           title,
+		HenleDifficulty: strings.Join(strings.Fields(cell.Contents().Not("a").Text()), " "),
+		Section:         section,
+		Composer:        composer,
+		Work:            ParseWorkTitle(title),
+	}
+	// every part is the instrument name followed by its grade circle, e.g. "Violin 6 Piano 5"
+	var instrument string
+	cell.Contents().Each(func(_ int, n *goquery.Selection) {
+		switch {
+		case goquery.NodeName(n) == "#text":
+			instrument += n.Text()
+		case n.Is("span.grade-circle"):
+			d, err := ParseDifficulty(strings.TrimSpace(instrument), n.Text())
+			if err == nil {
+				detail.Difficulties = append(detail.Difficulties, d)
+			} else {
+				b.addFieldError(fmt.Sprintf("%s.Difficulties[%d]", field, len(detail.Difficulties)), err)
+			}
+			instrument = ""
+		}
+	})
+	cell.Find("a").Each(func(_ int, s *goquery.Selection) {
+		detail.ABRSMDifficulty = append(detail.ABRSMDifficulty, s.Text())
+		if g, err := ParseABRSMGrade(s.Text()); err == nil {
+			detail.ABRSMGrades = append(detail.ABRSMGrades, g)
+		} else {
+			b.addFieldError(field+".ABRSMGrades", err)
+		}
+	})
+	return detail
+}
+
+// bookFieldSelectors are the selectors of the detail page the fields checked by checkMissing are extracted from.
+var bookFieldSelectors = map[string]string{
+	"Title":           "div.detail-hero h2.main-title",
+	"Composer":        "div.detail-hero h2.sub-title",
+	"Price":           "div.detail-hero div.column-cart > p.price",
+	"Instrumentation": "div.detail-hero ul.breadcrumb > li",
+	"HN":              "div.detail-hero div.short-facts > p (HN)",
+	"ISMN":            "div.detail-hero div.short-facts > p (ISMN)",
+	"Description":     "div.detail-hero div.article-text",
+	"Sections":        "div.article-contents ul",
+	"CoverLink":       "div.detail-hero figure.cover-container > a > img",
+}
+
+// checkMissing records the fields of b that were not found on the page.
+func (b *Book) checkMissing() {
+	fields := []struct {
+		name  string
+		empty bool
+	}{
+		{"Title", b.Title == ""},
+		{"Composer", b.Composer == ""},
+		{"Price", strings.TrimSpace(b.Price) == ""},
+		{"Instrumentation", b.Instrumentation == ""},
+		{"HN", b.HN == 0},
+		{"ISMN", b.ISMN == ""},
+		{"Description", b.Description == ""},
+		{"Sections", len(b.Details()) == 0},
+		{"CoverLink", b.CoverLink == ""},
+	}
+	for _, f := range fields {
+		if f.empty {
+			b.Missing = append(b.Missing, f.name)
+		}
+	}
+}
+
+func (b *Book) addFieldError(field string, err error) {
+	b.FieldErrors = append(b.FieldErrors, FieldError{field, err.Error()})
+}
+
+// childText returns the trimmed text of the elements of s matching selector.
+func childText(s *goquery.Selection, selector string) string {
+	return strings.TrimSpace(s.Find(selector).Text())
+}
+
+// childAttr returns the trimmed attribute of the first element of s matching selector.
+func childAttr(s *goquery.Selection, selector string, attr string) string {
+	if value, ok := s.Find(selector).Attr(attr); ok {
+		return strings.TrimSpace(value)
+	}
+	return ""
+}
+
+// absoluteURL resolves link against pageURL.
+func absoluteURL(pageURL *url.URL, link string) string {
+	u, err := url.Parse(link)
+	if err != nil || pageURL == nil {
+		return link
+	}
+	return pageURL.ResolveReference(u).String()
+}
@@ -0,0 +1,152 @@
+package henle
+
+import (
+	"fmt"
+	"github.com/bluemonarch21/matchmaker/pdf"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// pageFiles returns the files of the preview pages of the score of one width variant in the directory of a book,
+// in page order, with the manifest of the book. The pages are taken from the manifest, or from the directory
+// of the variant for books downloaded before manifests.
+func pageFiles(bookDir string, width string) ([]string, ImageManifest, error) {
+	manifest, err := ReadImageManifest(filepath.Join(bookDir, "manifest.json"))
+	if err != nil {
+		return nil, manifest, err
+	}
+	var files []string
+	for _, page := range manifest.Pages {
+		if page.Voice == "" && page.Variant == width {
+			files = append(files, filepath.Join(bookDir, filepath.FromSlash(page.File)))
+		}
+	}
+	if len(files) > 0 {
+		return files, manifest, nil
+	}
+	// page files are named by their zero padded number
+	files, err = filepath.Glob(filepath.Join(bookDir, width, "*.jpg"))
+	sort.Strings(files)
+	return files, manifest, err
+}
+
+// pdfInfo returns the document information of the PDF of the book hn, with the texts of book if it is not nil,
+// or else those of the pageflip the pages were downloaded from if it is not nil.
+func pdfInfo(hn int, book *Book, pageflip *PageflipInfo) pdf.Info {
+	info := pdf.Info{
+		Title:    fmt.Sprintf("HN %04d", hn),
+		Subject:  fmt.Sprintf("G. Henle Verlag HN %04d, preview pages", hn),
+		Keywords: fmt.Sprintf("HN %04d", hn),
+		Creator:  "matchmaker",
+	}
+	if book == nil && pageflip != nil {
+		if pageflip.SubTitle != "" {
+			info.Title = pageflip.SubTitle
+		}
+		info.Author = pageflip.MainTitle
+	}
+	if book != nil {
+		if book.Title != "" {
+			info.Title = book.Title
+		}
+		info.Author = book.Composer
+		if book.ISMN != "" {
+			info.Keywords += ", ISMN " + book.ISMN
+		}
+	}
+	return info
+}
+
+// WriteBookPDF writes the preview pages of one width variant of the book hn, downloaded under outDir
+// by ScrapeBookImages, to w as a PDF with one page per image.
+// The title and composer are taken from book, which may be nil, or else from the Pageflip of the manifest.
+func WriteBookPDF(outDir string, hn int, width string, book *Book, w io.Writer) error {
+	files, manifest, err := pageFiles(BookDir(outDir, hn), width)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no %s pages of HN %04d in %s", width, hn, outDir)
+	}
+	return writePDF(pdfInfo(hn, book, manifest.Pageflip), files, w)
+}
+
+// writePDF writes the JPEG files to w as a PDF with one page per file.
+func writePDF(info pdf.Info, files []string, w io.Writer) error {
+	doc := pdf.NewWriter(w)
+	doc.Info = info
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := doc.AddJPEG(data); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return doc.Close()
+}
+
+// DownloadedHNs returns the HNs of the books with a directory under outDir/henle, in increasing order.
+func DownloadedHNs(outDir string) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join(outDir, "henle"))
+	if err != nil {
+		return nil, err
+	}
+	var hns []int
+	for _, entry := range entries {
+		if hn, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() {
+			hns = append(hns, hn)
+		}
+	}
+	sort.Ints(hns)
+	return hns, nil
+}
+
+// WriteBookPDFs writes the PDF of the preview pages of every book hns, or of every downloaded book if empty,
+// to outDir/henle/<HN>/<HN>-<width>.pdf, see WriteBookPDF. books, which may be nil, holds the texts of the books.
+// Books without pages of the width variant are skipped.
+func WriteBookPDFs(outDir string, width string, hns []int, books Snapshot, stdout io.Writer) error {
+	if len(hns) == 0 {
+		var err error
+		if hns, err = DownloadedHNs(outDir); err != nil {
+			return err
+		}
+	}
+	for _, hn := range hns {
+		var book *Book
+		if b, ok := books[hn]; ok {
+			book = &b
+		}
+		filename := filepath.Join(BookDir(outDir, hn), fmt.Sprintf("%04d-%s.pdf", hn, width))
+		if err := writeBookPDFFile(outDir, hn, width, book, filename); err != nil {
+			fmt.Fprintf(stdout, "PDF of HN %04d error: %s\n", hn, err)
+			continue
+		}
+		fmt.Fprintf(stdout, "PDF of HN %04d written to %s\n", hn, filename)
+	}
+	return nil
+}
+
+func writeBookPDFFile(outDir string, hn int, width string, book *Book, filename string) error {
+	files, manifest, err := pageFiles(BookDir(outDir, hn), width)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no %s pages", width)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := writePDF(pdfInfo(hn, book, manifest.Pageflip), files, f); err != nil {
+		f.Close()
+		os.Remove(filename)
+		return err
+	}
+	return f.Close()
+}
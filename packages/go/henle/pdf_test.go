@@ -0,0 +1,63 @@
+package henle
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteBookPDFs(t *testing.T) {
+	outDir := t.TempDir()
+	bookDir := BookDir(outDir, 650)
+	manifest := ImageManifest{HN: 650, Pageflip: &PageflipInfo{MainTitle: "Albéniz, Isaac", SubTitle: "Iberia · Fourth Book"}}
+	for _, file := range []string{"w1500/0002.jpg", "w1500/0001.jpg", "violin/w1500/0001.jpg"} {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 30, 40)), nil); err != nil {
+			t.Fatal(err)
+		}
+		filename := filepath.Join(bookDir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		voice := ""
+		if strings.HasPrefix(file, "violin/") {
+			voice = "violin"
+		}
+		manifest.Set(PageImage{Page: len(manifest.Pages) + 1, Voice: voice, Variant: "w1500", File: file})
+	}
+	if err := manifest.WriteFile(manifestFile(outDir, "0650")); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	if err := WriteBookPDFs(outDir, "w1500", []int{650, 651}, nil, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(bookDir, "0650-w1500.pdf"))
+	if err != nil {
+		t.Fatalf("%s\n%s", err, stdout.String())
+	}
+	doc := string(data)
+	// the score pages only, not those of the voice
+	if !strings.Contains(doc, "/Count 2 >>") {
+		t.Error("the PDF does not have 2 pages")
+	}
+	for _, want := range []string{"/Title <FEFF", "/Author <FEFF", "/Keywords (HN 0650)"} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("the info of the PDF does not hold %s", want)
+		}
+	}
+	if !strings.Contains(stdout.String(), "PDF of HN 0651 error") {
+		t.Errorf("the book without pages is not reported, output: %q", stdout.String())
+	}
+	if _, err := os.Stat(filepath.Join(BookDir(outDir, 651), "0651-w1500.pdf")); err == nil {
+		t.Error("a PDF was written for the book without pages")
+	}
+}
@@ -0,0 +1,262 @@
+package henle
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/bluemonarch21/matchmaker/crawl"
+	"github.com/gocolly/colly"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Person is a composer or contributor with a profile page on henle.de.
+type Person struct {
+	ID          string // stable ID derived from the profile URL, see PersonID
+	URL         string
+	Name        string
+	Roles       []string // roles in the scraped books, e.g. "Composer", "Editor", "Fingering"
+	LifeDates   string   // as shown on the page, e.g. "1841–1904"
+	Born        int      // year, 0 if unknown
+	Died        int      // year, 0 if unknown or alive
+	Nationality string
+	Biography   string
+	HNs         []int // editions the person worked on
+}
+
+var personIDPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// PersonID returns the stable ID of the person with the given profile URL, e.g. "herttrich"
+// for https://www.henle.de/en/about-us/authors/?Name=Herttrich. It is "" for an empty URL.
+func PersonID(profileURL string) string {
+	if profileURL == "" {
+		return ""
+	}
+	u, err := url.Parse(profileURL)
+	if err != nil {
+		return ""
+	}
+	// the name is either a query parameter or the last part of the path
+	key := strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		key = key[i+1:]
+	}
+	q := u.Query()
+	if len(q) > 0 {
+		names := make([]string, 0, len(q))
+		for name := range q {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		key = q.Get(names[0])
+	}
+	return strings.Trim(personIDPattern.ReplaceAllString(strings.ToLower(key), "-"), "-")
+}
+
+var lifeDatesPattern = regexp.MustCompile(`(\d{4})\s*[–-]\s*(\d{4})?|\*\s*(\d{4})`)
+
+// ParsePerson parses a composer or contributor profile page.
+// pageURL is used to resolve relative links and to derive the ID of the person, ErrNoPageURL is returned if it is nil.
+func ParsePerson(r io.Reader, pageURL *url.URL) (Person, error) {
+	if pageURL == nil {
+		return Person{}, ErrNoPageURL
+	}
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return Person{}, err
+	}
+	p := Person{
+		ID:  PersonID(pageURL.String()),
+		URL: pageURL.String(),
+	}
+	hero := doc.Find("div.person-hero").First()
+	p.Name = childText(hero, "h2.main-title")
+	if p.Name == "" {
+		p.Name = childText(doc.Selection, "h1")
+	}
+	p.LifeDates = childText(hero, ".life-dates")
+	if p.LifeDates == "" {
+		p.LifeDates = childText(hero, "h2.sub-title")
+	}
+	if m := lifeDatesPattern.FindStringSubmatch(p.LifeDates); m != nil {
+		if m[3] != "" {
+			p.Born, _ = strconv.Atoi(m[3])
+		} else {
+			p.Born, _ = strconv.Atoi(m[1])
+			p.Died, _ = strconv.Atoi(m[2])
+		}
+	}
+	p.Nationality = childText(hero, ".nationality")
+	p.Biography = strings.Replace(childText(doc.Selection, "div.biography, div.article-text"), "\n", "\\n", -1)
+
+	// editions are listed like search results
+	for _, result := range searchResults(doc.Selection, pageURL) {
+		p.HNs = appendHN(p.HNs, result.HN)
+	}
+	return p, nil
+}
+
+// personIndex is a BookSink collecting the persons referenced by the books written to it.
+type personIndex struct {
+	persons map[string]*Person
+}
+
+func newPersonIndex() *personIndex {
+	return &personIndex{make(map[string]*Person)}
+}
+
+func (idx *personIndex) add(id string, profileURL string, name string, role string, hn int) {
+	p, ok := idx.persons[id]
+	if !ok {
+		p = &Person{ID: id, URL: profileURL, Name: name}
+		idx.persons[id] = p
+	}
+	if role != "" && !containsString(p.Roles, role) {
+		p.Roles = append(p.Roles, role)
+	}
+	p.HNs = appendHN(p.HNs, hn)
+}
+
+func (idx *personIndex) Write(book Book) error {
+	if book.ComposerID != "" {
+		idx.add(book.ComposerID, book.ComposerURL, book.Composer, "Composer", book.HN)
+	}
+	for _, author := range book.Authors {
+		if author.PersonID != "" {
+			idx.add(author.PersonID, author.URL, author.Name, author.Role, book.HN)
+		}
+	}
+	return nil
+}
+
+func (idx *personIndex) Close() error {
+	return nil
+}
+
+// merge fills in the profile of a person found on its page.
+func (idx *personIndex) merge(profile Person) {
+	p, ok := idx.persons[profile.ID]
+	if !ok {
+		return
+	}
+	if profile.Name != "" {
+		p.Name = profile.Name
+	}
+	p.LifeDates = profile.LifeDates
+	p.Born = profile.Born
+	p.Died = profile.Died
+	p.Nationality = profile.Nationality
+	p.Biography = profile.Biography
+	for _, hn := range profile.HNs {
+		p.HNs = appendHN(p.HNs, hn)
+	}
+}
+
+// sorted returns the persons ordered by ID, with their HNs in ascending order.
+func (idx *personIndex) sorted() []Person {
+	persons := make([]Person, 0, len(idx.persons))
+	for _, p := range idx.persons {
+		sort.Ints(p.HNs)
+		persons = append(persons, *p)
+	}
+	sort.Slice(persons, func(i, j int) bool { return persons[i].ID < persons[j].ID })
+	return persons
+}
+
+func appendHN(hns []int, hn int) []int {
+	if hn == 0 {
+		return hns
+	}
+	for _, h := range hns {
+		if h == hn {
+			return hns
+		}
+	}
+	return append(hns, hn)
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ScrapeContributors crawls the books of every query in opts like ScrapeBookDetails, writing them to sinks,
+// then visits the profile pages of their composers and contributors.
+// The persons are written to out as one JSON object per line, ordered by ID.
+// The report of the profile crawl is written next to the details report, with a "-persons" suffix.
+// opts.State is not used: the person index needs every book, including those completed before.
+func ScrapeContributors(verbose int, opts CrawlOptions, out io.Writer, sinks ...BookSink) error {
+	opts.State = nil
+	index := newPersonIndex()
+	crawlErr := ScrapeBookDetails(verbose, opts, append(sinks, index)...)
+
+	var verbout io.Writer
+	switch verbose {
+	case 0:
+		verbout, _ = os.Create("~console-output-persons.log")
+	default:
+		verbout = os.Stdout
+	}
+	c, err := opts.newCollector()
+	if err != nil {
+		return err
+	}
+	report := crawl.NewReport("henle persons")
+	if err := opts.Politeness.Apply(c, 2, report); err != nil {
+		return err
+	}
+	report.Track(c)
+	c.OnRequest(func(r *colly.Request) {
+		fmt.Fprintln(verbout, "c Visiting", r.URL.String())
+	})
+	c.OnResponse(func(response *colly.Response) {
+		p, err := ParsePerson(bytes.NewReader(response.Body), response.Request.URL)
+		if err != nil {
+			fmt.Fprintf(verbout, "ParsePerson %s error: %s\n", response.Request.URL, err)
+			report.Error(err)
+			return
+		}
+		report.Selector("div.person-hero h2.main-title, h1", p.Name == "")
+		report.Selector("div.biography, div.article-text", p.Biography == "")
+		report.Count("persons", 1)
+		// keep the ID of the link the person was found by, the page may have been redirected
+		p.ID = response.Ctx.Get("id")
+		index.merge(p)
+	})
+	for _, p := range index.sorted() {
+		if p.URL == "" {
+			continue
+		}
+		ctx := colly.NewContext()
+		ctx.Put("id", p.ID)
+		if err := c.Request("GET", p.URL, nil, ctx, nil); err != nil {
+			fmt.Fprintf(verbout, "c.Visiting %s error: %s\n", p.URL, err)
+		}
+	}
+
+	filename := opts.reportFile("henle-details-report.json")
+	filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + "-persons" + filepath.Ext(filename)
+	if err := report.WriteFile(filename); err != nil {
+		log.Println("report.WriteFile error:", err)
+	}
+
+	enc := json.NewEncoder(out)
+	for _, p := range index.sorted() {
+		if err := enc.Encode(p); err != nil {
+			return err
+		}
+	}
+	return crawlErr
+}
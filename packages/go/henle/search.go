@@ -0,0 +1,346 @@
+package henle
+
+import (
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/bluemonarch21/matchmaker/crawl"
+	"github.com/gocolly/colly"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SearchQuery describes one search on the https://www.henle.de/en/search/ page.
+// Empty fields are left out of the search URL.
+type SearchQuery struct {
+	Scoring    string // e.g. "Keyboard instruments", "String instruments"
+	Instrument string // e.g. "Piano solo", "Violin and Piano"
+	Composer   string
+	Text       string // free text search
+	Language   string // language path of the site, defaults to "en"
+}
+
+// DefaultSearchQuery is the piano solo search used when no query is given.
+var DefaultSearchQuery = SearchQuery{
+	Scoring:    "Keyboard instruments",
+	Instrument: "Piano solo",
+	Language:   "en",
+}
+
+// URL returns the search results page URL of the query.
+func (q SearchQuery) URL() string {
+	lang := q.Language
+	if lang == "" {
+		lang = "en"
+	}
+	v := url.Values{}
+	if q.Scoring != "" {
+		v.Set("Scoring", q.Scoring)
+	}
+	if q.Instrument != "" {
+		v.Set("Instrument", q.Instrument)
+	}
+	if q.Composer != "" {
+		v.Set("Composer", q.Composer)
+	}
+	if q.Text != "" {
+		v.Set("Searchstring", q.Text)
+	}
+	return fmt.Sprintf("https://www.henle.de/%s/search/?%s", lang, v.Encode())
+}
+
+func (q SearchQuery) String() string {
+	return q.URL()
+}
+
+// ParseSearchQuery parses a query given either as a henle.de search URL or as
+// "key=value" pairs joined by "&", with keys scoring, instrument, composer, text and lang.
+// For example: "scoring=String instruments&instrument=Violin and Piano".
+func ParseSearchQuery(s string) (SearchQuery, error) {
+	var q SearchQuery
+	if strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return q, err
+		}
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(parts) != 2 || parts[1] != "search" {
+			return q, fmt.Errorf("not a henle search URL: %s", s)
+		}
+		v := u.Query()
+		return SearchQuery{
+			Scoring:    v.Get("Scoring"),
+			Instrument: v.Get("Instrument"),
+			Composer:   v.Get("Composer"),
+			Text:       v.Get("Searchstring"),
+			Language:   parts[0],
+		}, nil
+	}
+	for _, pair := range strings.Split(s, "&") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return q, fmt.Errorf("invalid search query part %q", pair)
+		}
+		value := strings.TrimSpace(kv[1])
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "scoring":
+			q.Scoring = value
+		case "instrument":
+			q.Instrument = value
+		case "composer":
+			q.Composer = value
+		case "text":
+			q.Text = value
+		case "lang", "language":
+			q.Language = value
+		default:
+			return q, fmt.Errorf("unknown search query key %q", kv[0])
+		}
+	}
+	return q, nil
+}
+
+// CrawlOptions configures where a crawl starts.
+type CrawlOptions struct {
+	// Queries are the searches to start crawling from. DefaultSearchQuery is used when empty.
+	// Books reached by more than one query are only scraped once.
+	Queries []SearchQuery
+	// MaxPages limits the number of search results pages followed per query. 0 means no limit.
+	MaxPages int
+	// Languages are the language paths, e.g. "de", of which detail pages are scraped in addition
+	// and merged into Book.Localized. Only used by ScrapeBookDetails.
+	Languages []string
+	// Images selects the books, width variants and pages downloaded by ScrapeBookImages.
+	Images ImageOptions
+	// Covers configures the download of the covers of the books. Only used by ScrapeBookDetails.
+	Covers CoverOptions
+	// Politeness sets the delays, parallelism (2 by default), user agent, robots.txt handling and retries of the crawl.
+	Politeness crawl.Politeness
+	// ReportFile is where the JSON run report is written at the end of the crawl.
+	// Defaults to henle-details-report.json or henle-images-report.json.
+	ReportFile string
+	// CacheDir is the directory colly caches responses in, no cache if empty.
+	CacheDir string
+	// State, if not nil, persists the visited set, the frontier and the completed HNs of the crawl.
+	// The frontier of a resumed State is requested again before the search pages,
+	// and completed books are skipped. The caller opens and closes it.
+	State *crawl.State
+}
+
+// newCollector returns a collector of www.henle.de using the cache and state of o.
+func (o CrawlOptions) newCollector() (*colly.Collector, error) {
+	c := colly.NewCollector(
+		colly.AllowedDomains("www.henle.de"),
+		colly.CacheDir(o.CacheDir),
+	)
+	if o.State != nil {
+		if err := c.SetStorage(o.State); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// track adds the requests of c to the frontier of the state, if any.
+func (o CrawlOptions) track(c *colly.Collector, name string) {
+	if o.State != nil {
+		o.State.Track(c, name)
+	}
+}
+
+// requeue requests u again with the collector tracked as name when the crawl is resumed, see crawl.State.Requeue.
+// Called from OnScraped, it must be registered after track.
+func (o CrawlOptions) requeue(u string, name string) {
+	if o.State != nil {
+		o.State.Requeue(u, name)
+	}
+}
+
+// resume requests the frontier of the state again, see crawl.State.Resume.
+func (o CrawlOptions) resume(collectors map[string]*colly.Collector) []error {
+	if o.State == nil {
+		return nil
+	}
+	return o.State.Resume(collectors)
+}
+
+// completed tells whether the book hn was completed, in this run or a resumed one.
+func (o CrawlOptions) completed(hn int) bool {
+	return o.State != nil && hn != 0 && o.State.IsComplete(strconv.Itoa(hn))
+}
+
+func (o CrawlOptions) complete(hn int) {
+	if o.State != nil && hn != 0 {
+		o.State.Complete(strconv.Itoa(hn))
+	}
+}
+
+func (o CrawlOptions) reportFile(defaultName string) string {
+	if o.ReportFile == "" {
+		return defaultName
+	}
+	return o.ReportFile
+}
+
+func (o CrawlOptions) queries() []SearchQuery {
+	if len(o.Queries) == 0 {
+		return []SearchQuery{DefaultSearchQuery}
+	}
+	return o.Queries
+}
+
+// hnSet is a set of HN numbers safe for concurrent use.
+type hnSet struct {
+	mu sync.Mutex
+	m  map[int]bool
+}
+
+func newHNSet() *hnSet {
+	return &hnSet{m: make(map[int]bool)}
+}
+
+// add adds hn to the set and reports whether it was not there yet.
+func (s *hnSet) add(hn int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m[hn] {
+		return false
+	}
+	s.m[hn] = true
+	return true
+}
+
+// Selector of the link to the next search results page, see testdata/search-results*.html.
+// The last page has no such link.
+const nextPageSelector = "ul.pagination li.next > a"
+
+// Selector of the element stating the total number of search results, e.g. "1,204 titles".
+const resultCountSelector = ".search-results-header"
+
+var resultCountPattern = regexp.MustCompile(`(\d[\d.,]*)\s+(?:results?|titles?|hits|Treffer|Titel|Ergebnisse)`)
+
+// QueryStats counts what was seen while following the search results of one query.
+type QueryStats struct {
+	Query    SearchQuery
+	Pages    int // search results pages visited
+	Items    int // result items seen on those pages
+	Reported int // total number of results reported by Henle, 0 if unknown
+}
+
+// SearchStats collects QueryStats of every query of a crawl.
+type SearchStats struct {
+	mu      sync.Mutex
+	Queries []QueryStats
+}
+
+func newSearchStats(queries []SearchQuery) *SearchStats {
+	s := &SearchStats{Queries: make([]QueryStats, len(queries))}
+	for i, q := range queries {
+		s.Queries[i].Query = q
+	}
+	return s
+}
+
+func (s *SearchStats) update(i int, f func(q *QueryStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i >= 0 && i < len(s.Queries) {
+		f(&s.Queries[i])
+	}
+}
+
+// String summarizes the stats, one line per query followed by the totals.
+func (s *SearchStats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b strings.Builder
+	var total QueryStats
+	for _, q := range s.Queries {
+		fmt.Fprintf(&b, "%s: %d result pages, %d items seen of %d reported\n", q.Query, q.Pages, q.Items, q.Reported)
+		total.Pages += q.Pages
+		total.Items += q.Items
+		total.Reported += q.Reported
+	}
+	fmt.Fprintf(&b, "Total: %d result pages, %d items seen of %d reported", total.Pages, total.Items, total.Reported)
+	return b.String()
+}
+
+// visitSearchPages starts c on the first search results page of each query.
+// setupSearchPagination must have been called on c with the same stats.
+func visitSearchPages(c *colly.Collector, queries []SearchQuery) []error {
+	var errs []error
+	for i, q := range queries {
+		ctx := colly.NewContext()
+		ctx.Put("query", strconv.Itoa(i))
+		ctx.Put("page", "1")
+		// the first page was already visited by a resumed crawl or another query
+		if err := c.Request("GET", q.URL(), nil, ctx, nil); err != nil && err != colly.ErrAlreadyVisited {
+			errs = append(errs, fmt.Errorf("visiting %s: %w", q.URL(), err))
+		}
+	}
+	return errs
+}
+
+// setupSearchPagination makes c count the results of the search pages it visits
+// and follow the links to the next results pages, until there are none left or maxPages is reached.
+func setupSearchPagination(c *colly.Collector, stats *SearchStats, maxPages int, stdout io.Writer) {
+	c.OnHTML("article.result-item", func(e *colly.HTMLElement) {
+		i, _ := strconv.Atoi(e.Request.Ctx.Get("query"))
+		stats.update(i, func(q *QueryStats) { q.Items++ })
+	})
+
+	c.OnHTML(":root", func(e *colly.HTMLElement) {
+		i, _ := strconv.Atoi(e.Request.Ctx.Get("query"))
+		page, _ := strconv.Atoi(e.Request.Ctx.Get("page"))
+		stats.update(i, func(q *QueryStats) {
+			q.Pages++
+			if q.Reported == 0 {
+				q.Reported = reportedResultCount(e)
+			}
+		})
+
+		if maxPages > 0 && page >= maxPages {
+			return
+		}
+		next := nextPageLink(e)
+		if next == "" {
+			return
+		}
+		fmt.Fprintf(stdout, "Next page found: %s\n", next)
+		ctx := colly.NewContext()
+		ctx.Put("query", strconv.Itoa(i))
+		ctx.Put("page", strconv.Itoa(page+1))
+		if err := c.Request("GET", next, nil, ctx, nil); err != nil {
+			fmt.Fprintf(stdout, "c.Visiting %s error: %s\n", next, err)
+		}
+	})
+}
+
+// nextPageLink returns the absolute URL of the next search results page, or "" if there is none.
+func nextPageLink(e *colly.HTMLElement) string {
+	link, ok := e.DOM.Find(nextPageSelector).First().Attr("href")
+	if !ok || link == "" || strings.HasPrefix(link, "#") {
+		return ""
+	}
+	return e.Request.AbsoluteURL(link)
+}
+
+// reportedResultCount returns the total number of results stated on a search page, or 0 if not found.
+func reportedResultCount(e *colly.HTMLElement) int {
+	count := 0
+	e.DOM.Find(resultCountSelector).EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		m := resultCountPattern.FindStringSubmatch(s.Text())
+		if m == nil {
+			return true
+		}
+		count, _ = strconv.Atoi(strings.NewReplacer(".", "", ",", "").Replace(m[1]))
+		return false
+	})
+	return count
+}
@@ -0,0 +1,73 @@
+package henle
+
+import (
+	"bytes"
+	"github.com/gocolly/colly"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// searchPages are the fixtures of the pages of one search, by their page parameter.
+var searchPages = map[string]string{
+	"":  "search-results",
+	"2": "search-results-page-2",
+	"3": "search-results-page-3",
+}
+
+func TestSearchPagination(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := searchPages[r.URL.Query().Get("page")]
+		if r.URL.Path != "/en/search/" || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join("testdata", name+".html"))
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	tests := []struct {
+		maxPages int
+		pages    []string // page parameters of the requests, in order
+		items    int
+	}{
+		{0, []string{"", "2", "3"}, 6},
+		{2, []string{"", "2"}, 5},
+		{1, []string{""}, 3},
+	}
+	for _, test := range tests {
+		c := colly.NewCollector()
+		// the searches on www.henle.de are sent to the test server
+		c.OnRequest(func(r *colly.Request) {
+			r.URL.Scheme, r.URL.Host = serverURL.Scheme, serverURL.Host
+		})
+		var pages []string
+		c.OnResponse(func(r *colly.Response) {
+			pages = append(pages, r.Request.URL.Query().Get("page"))
+		})
+		queries := []SearchQuery{DefaultSearchQuery}
+		stats := newSearchStats(queries)
+		var stdout bytes.Buffer
+		setupSearchPagination(c, stats, test.maxPages, &stdout)
+		if errs := visitSearchPages(c, queries); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+
+		if !reflect.DeepEqual(pages, test.pages) {
+			t.Errorf("max pages %d: visited pages %q, want %q", test.maxPages, pages, test.pages)
+		}
+		got := stats.Queries[0]
+		if got.Pages != len(test.pages) || got.Items != test.items || got.Reported != 1204 {
+			t.Errorf("max pages %d: got %d pages, %d items, %d reported, want %d, %d and 1204",
+				test.maxPages, got.Pages, got.Items, got.Reported, len(test.pages), test.items)
+		}
+		if n := strings.Count(stdout.String(), "Next page found"); n != len(test.pages)-1 {
+			t.Errorf("max pages %d: %d next pages found, want %d, output: %q", test.maxPages, n, len(test.pages)-1, stdout.String())
+		}
+	}
+}
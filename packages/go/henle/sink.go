@@ -0,0 +1,147 @@
+package henle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"log"
+	"strings"
+	"sync"
+)
+
+// BookSink receives the books scraped by ScrapeBookDetails.
+// Implement it to plug in your own output.
+type BookSink interface {
+	// Write is called once for every scraped book.
+	Write(Book) error
+	// Close is called once after the last book has been written.
+	Close() error
+}
+
+// JSONSink writes books as indented JSON objects, one after another.
+type JSONSink struct {
+	enc *json.Encoder
+}
+
+// NewJSONSink returns a JSONSink writing to w. Closing the sink does not close w.
+func NewJSONSink(w io.Writer) *JSONSink {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return &JSONSink{enc}
+}
+
+func (s *JSONSink) Write(book Book) error {
+	return s.enc.Encode(book)
+}
+
+func (s *JSONSink) Close() error {
+	return nil
+}
+
+// MongoSink inserts books into a mongodb collection.
+type MongoSink struct {
+	collection *mongo.Collection
+}
+
+// NewMongoSink returns a MongoSink inserting into collection.
+func NewMongoSink(collection *mongo.Collection) *MongoSink {
+	return &MongoSink{collection}
+}
+
+func (s *MongoSink) Write(book Book) error {
+	_, err := s.collection.InsertOne(context.Background(), book)
+	return err
+}
+
+func (s *MongoSink) Close() error {
+	return nil
+}
+
+// SinkError collects the errors one sink returned while writing and closing.
+type SinkError struct {
+	Sink   BookSink
+	Errors []error
+}
+
+func (e *SinkError) Error() string {
+	return fmt.Sprintf("%T: %d errors, first: %s", e.Sink, len(e.Errors), e.Errors[0])
+}
+
+// FanOutError lists the sinks of a FanOut that returned errors.
+type FanOutError []*SinkError
+
+func (e FanOutError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// FanOut is a BookSink sending every book to each of its sinks.
+// Each sink runs in its own goroutine behind a buffered channel. Write blocks
+// while the buffer of any sink is full, so a slow sink slows the crawl down instead of piling up books.
+type FanOut struct {
+	sinks []BookSink
+	chans []chan Book
+	errs  []*SinkError
+	wg    sync.WaitGroup
+}
+
+// NewFanOut starts a FanOut to sinks, buffering up to buffer books per sink.
+func NewFanOut(buffer int, sinks ...BookSink) *FanOut {
+	f := &FanOut{
+		sinks: sinks,
+		chans: make([]chan Book, len(sinks)),
+		errs:  make([]*SinkError, len(sinks)),
+	}
+	for i, sink := range sinks {
+		f.chans[i] = make(chan Book, buffer)
+		f.errs[i] = &SinkError{Sink: sink}
+		f.wg.Add(1)
+		go f.consume(sink, f.chans[i], f.errs[i])
+	}
+	return f
+}
+
+func (f *FanOut) consume(sink BookSink, books <-chan Book, sinkErr *SinkError) {
+	defer f.wg.Done()
+	for book := range books {
+		if err := sink.Write(book); err != nil {
+			log.Printf("%T: writing HN %04d error: %s", sink, book.HN, err)
+			sinkErr.Errors = append(sinkErr.Errors, err)
+		}
+	}
+}
+
+// Write sends book to every sink. It must not be called after Close.
+func (f *FanOut) Write(book Book) error {
+	for _, books := range f.chans {
+		books <- book
+	}
+	return nil
+}
+
+// Close waits for every sink to write its pending books, then closes the sinks.
+// The returned error is a FanOutError if any sink failed.
+func (f *FanOut) Close() error {
+	for _, books := range f.chans {
+		close(books)
+	}
+	f.wg.Wait()
+	var errs FanOutError
+	for i, sink := range f.sinks {
+		if err := sink.Close(); err != nil {
+			f.errs[i].Errors = append(f.errs[i].Errors, err)
+		}
+		if len(f.errs[i].Errors) > 0 {
+			errs = append(errs, f.errs[i])
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
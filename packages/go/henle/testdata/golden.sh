@@ -0,0 +1,26 @@
+#!/bin/sh
+# Regenerates the golden JSON files from the HTML fixtures in this directory.
+# Run from packages/go: sh henle/testdata/golden.sh
+# The tests of package henle fail when the parsers no longer produce these files.
+set -e
+dir=henle/testdata
+parse() {
+	go run . parse "$1" "$dir/$2.html" --url "$3" > "$dir/$2.json"
+}
+parse search search-results "https://www.henle.de/en/search/?Scoring=Keyboard+instruments&Instrument=Piano+solo"
+parse search search-results-page-2 "https://www.henle.de/en/search/?Scoring=Keyboard+instruments&Instrument=Piano+solo&page=2"
+parse search search-results-page-3 "https://www.henle.de/en/search/?Scoring=Keyboard+instruments&Instrument=Piano+solo&page=3"
+parse details detail-single-title "https://www.henle.de/en/detail/?Title=Allegro+barbaro_1400"
+parse details detail-header "https://www.henle.de/en/detail/?Title=Chants+d%27Espagne+op.+232_782"
+parse details detail-hidden-items "https://www.henle.de/en/detail/?Title=Selected+Piano+Works_393"
+parse details detail-two-abrsm "https://www.henle.de/en/detail/?Title=Piano+Sonata+no.+26+E+flat+major+op.+81a+%28Les+Adieux%29_1223"
+parse details detail-string-authors "https://www.henle.de/en/detail/?Title=Volume+II_353"
+parse details detail-missing-fields "https://www.henle.de/en/detail/?Title=Nocturnes_185"
+parse details detail-movements "https://www.henle.de/en/detail/?Title=Sonatinas+and+Rondos_44"
+parse details detail-string-quartet "https://www.henle.de/en/detail/?Title=String+Quartets+op.+18_737"
+parse person person "https://www.henle.de/en/about-us/authors/?Name=Herttrich"
+parse person composer "https://www.henle.de/en/composers/?Composer=Bartok"
+parse pageflip pageflip "https://www.henle.de/en/detail/?pageflip=0650"
+parse pageflip pageflip-voices "https://www.henle.de/en/detail/?pageflip=0007"
+parse pageflip pageflip-voices-only "https://www.henle.de/en/detail/?pageflip=0008"
+go run . parse titles "$dir/titles.txt" > "$dir/titles.json"
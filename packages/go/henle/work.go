@@ -0,0 +1,144 @@
+package henle
+
+import (
+	"regexp"
+	"strings"
+)
+
+// WorkInfo is the structured information in the title of a piece,
+// e.g. "Piano Sonata no. 26 E flat major op. 81a (Les Adieux)".
+type WorkInfo struct {
+	Type       string // leading words of the title, usually the genre, e.g. "Piano Sonata"
+	Number     string // number within the type, e.g. "26"
+	Key        string // tonic, e.g. "E flat", "" if not given
+	Mode       string // "major" or "minor", "" if not given
+	Catalogues []CatalogueNumber
+	Nickname   string // e.g. "Les Adieux"
+}
+
+// CatalogueNumber is an opus or work catalogue number, e.g. "op. 2 no. 1", "BWV 846" or "Hob. XVI:52".
+type CatalogueNumber struct {
+	System string // "op", "BWV", "K", "Hob", "D", "WoO", "Anh", "HWV", "Sz", "BB", "L", "RV", "S", "MWV" or "TWV"
+	Number string // e.g. "81a", "XVI:52", "846-869", or "posth." if unnumbered
+	Item   string // number within the opus, e.g. "1" of "op. 2 no. 1"
+	// Posthumous tells whether the number was given after the death of the composer,
+	// e.g. "op. posth. 72 no. 1" or "op. posth."
+	Posthumous bool `json:",omitempty"`
+}
+
+// catalogueDots are the systems abbreviated with a dot.
+var catalogueDots = map[string]bool{"op": true, "K": true, "Hob": true, "Anh": true, "L": true, "S": true}
+
+func (c CatalogueNumber) String() string {
+	s := c.System
+	if catalogueDots[s] {
+		s += "."
+	}
+	if c.Posthumous && c.Number != "posth." {
+		s += " posth."
+	}
+	s += " " + c.Number
+	if c.Item != "" {
+		s += " no. " + c.Item
+	}
+	return s
+}
+
+var (
+	cataloguePattern    = regexp.MustCompile(`\b((?i:op(?:us|\.)?)|BWV|KV|K\.?|Hob\.?|D\.?|WoO|Anh\.?|HWV|Sz\.?|BB|L\.?|RV|S\.?|MWV|TWV)\s*(posth\.\s*)?((?:[IVXL]+\s*[:/]\s*)?\d+[a-z]?(?:\s*[-–]\s*\d+[a-z]?)?|posth\.?)(?:,?\s*no\.\s*(\d+[a-z]?))?`)
+	keyPattern          = regexp.MustCompile(`\b(?:in\s+)?([A-Ga-g])(?:(?:\s+|-)(flat|sharp)|(♭|♯))?\s+(major|minor)\b`)
+	numberPattern       = regexp.MustCompile(`\b[Nn]o\.\s*(\d+[a-z]?)`)
+	nicknamePattern     = regexp.MustCompile(`\(([^()]*)\)|[“„"]([^”“"]*)[”“"]`)
+	altNumberPattern    = regexp.MustCompile(`^\d+[a-z]*$`)
+	altCataloguePattern = regexp.MustCompile(`^\s*\((\d+[a-z]*)\)`)
+	yearPattern         = regexp.MustCompile(`^(?:\d{4}(?:\s*[-–/]\s*\d{2,4})?|posth\.?)$`)
+)
+
+// ParseWorkTitle extracts the work type, number, key, catalogue numbers and nickname of the title of a piece.
+// Parts that are not found are left empty, the Type of a title without any of them is the whole title.
+func ParseWorkTitle(title string) WorkInfo {
+	var w WorkInfo
+
+	// nicknames and alternative catalogue numbers are in parentheses or quotes
+	rest := nicknamePattern.ReplaceAllStringFunc(title, func(m string) string {
+		sub := nicknamePattern.FindStringSubmatch(m)
+		text := strings.TrimSpace(sub[1] + sub[2])
+		switch {
+		case cataloguePattern.FindString(text) == text:
+			return " " + text + " "
+		case yearPattern.MatchString(text):
+		case altNumberPattern.MatchString(text):
+			// K. 331 (300i)
+			return " (" + text + ") "
+		case w.Nickname == "":
+			w.Nickname = text
+		}
+		return " "
+	})
+	rest = strings.Join(strings.Fields(rest), " ")
+
+	end := len(rest) // start of the first part after the type
+	catalogues := cataloguePattern.FindAllStringSubmatchIndex(rest, -1)
+	for _, m := range catalogues {
+		system := strings.TrimSuffix(rest[m[2]:m[3]], ".")
+		if strings.EqualFold(system, "op") || strings.EqualFold(system, "opus") {
+			system = "op"
+		} else if system == "KV" {
+			system = "K"
+		}
+		c := CatalogueNumber{System: system, Number: strings.Join(strings.Fields(rest[m[6]:m[7]]), "")}
+		c.Posthumous = m[4] >= 0 || strings.HasPrefix(c.Number, "posth")
+		if m[8] >= 0 {
+			c.Item = rest[m[8]:m[9]]
+		}
+		w.Catalogues = append(w.Catalogues, c)
+		if alt := altCataloguePattern.FindStringSubmatch(rest[m[1]:]); alt != nil {
+			w.Catalogues = append(w.Catalogues, CatalogueNumber{System: system, Number: alt[1]})
+		}
+		if m[0] < end {
+			end = m[0]
+		}
+	}
+
+	if m := keyPattern.FindStringSubmatchIndex(rest); m != nil {
+		w.Key = strings.ToUpper(rest[m[2]:m[3]])
+		switch {
+		case m[4] >= 0:
+			w.Key += " " + rest[m[4]:m[5]]
+		case m[6] >= 0 && rest[m[6]:m[7]] == "♭":
+			w.Key += " flat"
+		case m[6] >= 0:
+			w.Key += " sharp"
+		}
+		w.Mode = rest[m[8]:m[9]]
+		if m[0] < end {
+			end = m[0]
+		}
+	}
+
+	for _, m := range numberPattern.FindAllStringSubmatchIndex(rest, -1) {
+		if inSpans(catalogues, m[0]) {
+			// "no. 1" of "op. 2 no. 1"
+			continue
+		}
+		w.Number = rest[m[2]:m[3]]
+		if m[0] < end {
+			end = m[0]
+		}
+		break
+	}
+
+	w.Type = strings.TrimSpace(strings.TrimRight(rest[:end], " ,–-·"))
+	w.Type = strings.TrimSuffix(w.Type, " in")
+	return w
+}
+
+// inSpans tells whether i is within one of the matches of FindAllStringSubmatchIndex.
+func inSpans(matches [][]int, i int) bool {
+	for _, m := range matches {
+		if m[0] <= i && i < m[1] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package henle
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readTitles returns the non-empty lines of testdata/titles.txt, titles of Henle detail pages,
+// those of the HTML fixtures first.
+func readTitles(t *testing.T) []string {
+	t.Helper()
+	file, err := os.Open(filepath.Join("testdata", "titles.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var titles []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if title := strings.TrimSpace(scanner.Text()); title != "" {
+			titles = append(titles, title)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return titles
+}
+
+func TestParseWorkTitle(t *testing.T) {
+	// the expected works are the titles.json written by the parse command, see golden.sh
+	data, err := os.ReadFile(filepath.Join("testdata", "titles.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests []struct {
+		Title string
+		Work  WorkInfo
+	}
+	if err := json.Unmarshal(data, &tests); err != nil {
+		t.Fatal(err)
+	}
+	titles := readTitles(t)
+	if len(tests) != len(titles) {
+		t.Fatalf("titles.json has %d titles, titles.txt %d, regenerate with sh henle/testdata/golden.sh", len(tests), len(titles))
+	}
+	for i, test := range tests {
+		if test.Title != titles[i] {
+			t.Fatalf("line %d of titles.txt is %q, titles.json has %q", i+1, titles[i], test.Title)
+		}
+		t.Run(test.Title, func(t *testing.T) {
+			got, want := ParseWorkTitle(test.Title), test.Work
+			if got.Type != want.Type {
+				t.Errorf("Type = %q, want %q", got.Type, want.Type)
+			}
+			if got.Number != want.Number {
+				t.Errorf("Number = %q, want %q", got.Number, want.Number)
+			}
+			if got.Key != want.Key {
+				t.Errorf("Key = %q, want %q", got.Key, want.Key)
+			}
+			if got.Mode != want.Mode {
+				t.Errorf("Mode = %q, want %q", got.Mode, want.Mode)
+			}
+			if len(got.Catalogues) != 0 || len(want.Catalogues) != 0 {
+				if !reflect.DeepEqual(got.Catalogues, want.Catalogues) {
+					t.Errorf("Catalogues = %v, want %v", got.Catalogues, want.Catalogues)
+				}
+			}
+			if got.Nickname != want.Nickname {
+				t.Errorf("Nickname = %q, want %q", got.Nickname, want.Nickname)
+			}
+		})
+	}
+}
+
+func TestCatalogueNumberString(t *testing.T) {
+	tests := []struct {
+		title string
+		want  []string
+	}{
+		{"Nocturne e minor op. posth. 72 no. 1", []string{"op. posth. 72 no. 1"}},
+		{"Waltz op. posth.", []string{"op. posth."}},
+		{"Piano Sonata A major K. 331 (300i)", []string{"K. 331", "K. 300i"}},
+		{"Impromptu c minor op. 90 no. 1 D 899", []string{"op. 90 no. 1", "D 899"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, c := range ParseWorkTitle(test.title).Catalogues {
+			got = append(got, c.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("catalogue numbers of %q are %q, want %q", test.title, got, test.want)
+		}
+	}
+}
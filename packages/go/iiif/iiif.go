@@ -0,0 +1,219 @@
+// Package iiif holds the resources of the IIIF Presentation API 3.0 and the image information
+// of the IIIF Image API 3.0 at compliance level 0, see https://iiif.io/api/.
+package iiif
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	PresentationContext = "http://iiif.io/api/presentation/3/context.json"
+	ImageContext        = "http://iiif.io/api/image/3/context.json"
+	ImageProtocol       = "http://iiif.io/api/image"
+)
+
+// LangMap is a language map, e.g. {"en": ["Piano Sonata"]}. Use "none" for texts without a language.
+type LangMap map[string][]string
+
+// Text returns a language map of one text in lang.
+func Text(lang string, text string) LangMap {
+	return LangMap{lang: {text}}
+}
+
+// MetadataEntry is a label and value pair shown to the user.
+type MetadataEntry struct {
+	Label LangMap `json:"label"`
+	Value LangMap `json:"value"`
+}
+
+// Manifest describes a book, one Canvas per page.
+type Manifest struct {
+	Context           string          `json:"@context"`
+	ID                string          `json:"id"`
+	Type              string          `json:"type"`
+	Label             LangMap         `json:"label"`
+	Summary           LangMap         `json:"summary,omitempty"`
+	Metadata          []MetadataEntry `json:"metadata,omitempty"`
+	RequiredStatement *MetadataEntry  `json:"requiredStatement,omitempty"`
+	Homepage          []Resource      `json:"homepage,omitempty"`
+	Thumbnail         []Resource      `json:"thumbnail,omitempty"`
+	Items             []Canvas        `json:"items"`
+}
+
+// NewManifest returns an empty Manifest with the given id and label.
+func NewManifest(id string, label LangMap) Manifest {
+	return Manifest{Context: PresentationContext, ID: id, Type: "Manifest", Label: label, Items: []Canvas{}}
+}
+
+// Canvas is a page of a Manifest.
+type Canvas struct {
+	ID     string           `json:"id"`
+	Type   string           `json:"type"`
+	Label  LangMap          `json:"label,omitempty"`
+	Width  int              `json:"width"`
+	Height int              `json:"height"`
+	Items  []AnnotationPage `json:"items"`
+}
+
+// AnnotationPage lists the annotations of a Canvas.
+type AnnotationPage struct {
+	ID    string       `json:"id"`
+	Type  string       `json:"type"`
+	Items []Annotation `json:"items"`
+}
+
+// Annotation associates a resource with a Canvas, e.g. paints an image on it.
+type Annotation struct {
+	ID         string   `json:"id"`
+	Type       string   `json:"type"`
+	Motivation string   `json:"motivation"`
+	Body       Resource `json:"body"`
+	Target     string   `json:"target"`
+}
+
+// Resource is an external resource, e.g. an image or a web page.
+type Resource struct {
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Label   LangMap   `json:"label,omitempty"`
+	Format  string    `json:"format,omitempty"`
+	Width   int       `json:"width,omitempty"`
+	Height  int       `json:"height,omitempty"`
+	Service []Service `json:"service,omitempty"`
+}
+
+// Service is an IIIF Image API service of an image resource.
+type Service struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Profile string `json:"profile"`
+}
+
+// ImageCanvas returns a Canvas of the size of an image, painted with it.
+// imageService is the id of the Image API service of the image, serving it as full/max/0/default.jpg.
+func ImageCanvas(id string, label LangMap, imageService string, width int, height int) Canvas {
+	return Canvas{
+		ID:     id,
+		Type:   "Canvas",
+		Label:  label,
+		Width:  width,
+		Height: height,
+		Items: []AnnotationPage{{
+			ID:   id + "/page",
+			Type: "AnnotationPage",
+			Items: []Annotation{{
+				ID:         id + "/annotation",
+				Type:       "Annotation",
+				Motivation: "painting",
+				Body:       ImageResource(imageService, width, height),
+				Target:     id,
+			}},
+		}},
+	}
+}
+
+// ImageResource returns the JPEG image of the full size of an Image API service.
+func ImageResource(imageService string, width int, height int) Resource {
+	return Resource{
+		ID:      imageService + "/full/max/0/default.jpg",
+		Type:    "Image",
+		Format:  "image/jpeg",
+		Width:   width,
+		Height:  height,
+		Service: []Service{{ID: imageService, Type: "ImageService3", Profile: "level0"}},
+	}
+}
+
+// Size is a width and height of an image.
+type Size struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// ImageInfo is the info.json of an image service at compliance level 0.
+// Sizes lists the sizes of the image that are available, the full size included.
+type ImageInfo struct {
+	Context  string `json:"@context"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Protocol string `json:"protocol"`
+	Profile  string `json:"profile"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Sizes    []Size `json:"sizes,omitempty"`
+}
+
+// NewImageInfo returns the info.json of the image service id of an image of the given full size.
+func NewImageInfo(id string, width int, height int, sizes []Size) ImageInfo {
+	return ImageInfo{
+		Context:  ImageContext,
+		ID:       id,
+		Type:     "ImageService3",
+		Protocol: ImageProtocol,
+		Profile:  "level0",
+		Width:    width,
+		Height:   height,
+		Sizes:    sizes,
+	}
+}
+
+// ImageRequest is the path of an Image API request after the image service id,
+// {region}/{size}/{rotation}/{quality}.{format}.
+type ImageRequest struct {
+	Region   string
+	Size     string
+	Rotation string
+	Quality  string
+	Format   string
+}
+
+// ParseImageRequest parses "{region}/{size}/{rotation}/{quality}.{format}".
+func ParseImageRequest(path string) (ImageRequest, error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 4 {
+		return ImageRequest{}, fmt.Errorf("invalid image request %q", path)
+	}
+	i := strings.LastIndex(parts[3], ".")
+	if i < 0 {
+		return ImageRequest{}, fmt.Errorf("missing format in image request %q", path)
+	}
+	return ImageRequest{parts[0], parts[1], parts[2], parts[3][:i], parts[3][i+1:]}, nil
+}
+
+// Match returns which of sizes, the available sizes of an image with the largest one first,
+// the request selects at compliance level 0: the full region of one of the sizes, unrotated, as default JPEG.
+func (r ImageRequest) Match(sizes []Size) (Size, error) {
+	if r.Region != "full" || r.Rotation != "0" || r.Quality != "default" || r.Format != "jpg" {
+		return Size{}, fmt.Errorf("only full/<size>/0/default.jpg is supported")
+	}
+	if len(sizes) == 0 {
+		return Size{}, fmt.Errorf("no image")
+	}
+	if r.Size == "max" {
+		return sizes[0], nil
+	}
+	// "w,", ",h" or "w,h", 0 for a dimension left out
+	var w, h int
+	wh := strings.SplitN(r.Size, ",", 2)
+	if len(wh) != 2 || wh[0] == "" && wh[1] == "" {
+		return Size{}, fmt.Errorf("invalid size %q", r.Size)
+	}
+	for i, dim := range []*int{&w, &h} {
+		if wh[i] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(wh[i])
+		if err != nil || n <= 0 {
+			return Size{}, fmt.Errorf("invalid size %q", r.Size)
+		}
+		*dim = n
+	}
+	for _, s := range sizes {
+		if (w == 0 || w == s.Width) && (h == 0 || h == s.Height) {
+			return s, nil
+		}
+	}
+	return Size{}, fmt.Errorf("size %q is not available", r.Size)
+}
@@ -0,0 +1,81 @@
+package iiif
+
+import "testing"
+
+func TestParseImageRequest(t *testing.T) {
+	tests := []struct {
+		path string
+		want ImageRequest
+	}{
+		{"/full/max/0/default.jpg", ImageRequest{"full", "max", "0", "default", "jpg"}},
+		{"full/500,/0/default.jpg", ImageRequest{"full", "500,", "0", "default", "jpg"}},
+		{"0,0,100,100/max/90/gray.png", ImageRequest{"0,0,100,100", "max", "90", "gray", "png"}},
+	}
+	for _, test := range tests {
+		got, err := ParseImageRequest(test.path)
+		if err != nil {
+			t.Errorf("ParseImageRequest(%q) error: %s", test.path, err)
+		} else if got != test.want {
+			t.Errorf("ParseImageRequest(%q) = %+v, want %+v", test.path, got, test.want)
+		}
+	}
+
+	for _, path := range []string{"/full/max/0/default", "/full/max/default.jpg", "/full/max/0/default.jpg/x", ""} {
+		if r, err := ParseImageRequest(path); err == nil {
+			t.Errorf("ParseImageRequest(%q) = %+v, want an error", path, r)
+		}
+	}
+}
+
+func TestImageRequestMatch(t *testing.T) {
+	sizes := []Size{{1500, 2121}, {1000, 1414}, {500, 707}}
+	tests := []struct {
+		path string
+		want Size
+		ok   bool
+	}{
+		{"/full/max/0/default.jpg", Size{1500, 2121}, true},
+		{"/full/1500,/0/default.jpg", Size{1500, 2121}, true},
+		{"/full/1000,/0/default.jpg", Size{1000, 1414}, true},
+		{"/full/500,/0/default.jpg", Size{500, 707}, true},
+		{"/full/,707/0/default.jpg", Size{500, 707}, true},
+		{"/full/1000,1414/0/default.jpg", Size{1000, 1414}, true},
+		// sizes that are not listed
+		{"/full/750,/0/default.jpg", Size{}, false},
+		{"/full/1000,707/0/default.jpg", Size{}, false},
+		{"/full/pct:50/0/default.jpg", Size{}, false},
+		{"/full/^max/0/default.jpg", Size{}, false},
+		{"/full/,/0/default.jpg", Size{}, false},
+		{"/full/-500,/0/default.jpg", Size{}, false},
+		// regions
+		{"/square/max/0/default.jpg", Size{}, false},
+		{"/0,0,500,500/max/0/default.jpg", Size{}, false},
+		{"/pct:0,0,50,50/max/0/default.jpg", Size{}, false},
+		// rotations
+		{"/full/max/90/default.jpg", Size{}, false},
+		{"/full/max/!0/default.jpg", Size{}, false},
+		// qualities and formats
+		{"/full/max/0/color.jpg", Size{}, false},
+		{"/full/max/0/gray.jpg", Size{}, false},
+		{"/full/max/0/default.png", Size{}, false},
+	}
+	for _, test := range tests {
+		r, err := ParseImageRequest(test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.Match(sizes)
+		if test.ok && err != nil {
+			t.Errorf("%s error: %s", test.path, err)
+		} else if test.ok && got != test.want {
+			t.Errorf("%s matches %+v, want %+v", test.path, got, test.want)
+		} else if !test.ok && err == nil {
+			t.Errorf("%s matches %+v, want an error", test.path, got)
+		}
+	}
+
+	r, _ := ParseImageRequest("/full/max/0/default.jpg")
+	if got, err := r.Match(nil); err == nil {
+		t.Errorf("got %+v without sizes, want an error", got)
+	}
+}
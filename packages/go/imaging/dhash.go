@@ -0,0 +1,107 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+)
+
+// blankLevel is the gray level, out of 0xffff, above which a pixel counts as paper when trimming margins.
+const blankLevel = 0xe000
+
+// DHash returns the 64 bit difference hash of img: the content, without its blank margins, is scaled
+// to 9x8 gray pixels and every bit tells whether a pixel is brighter than its right neighbour.
+// Pages engraved alike hash alike, whatever their resolution, compression and margins.
+// Blank images hash to 0.
+func DHash(img image.Image) uint64 {
+	b := contentBounds(img)
+	if b.Empty() {
+		return 0
+	}
+	const w, h = 9, 8
+	var gray [h][w]uint64
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := b.Min.Y + (y+1)*b.Dy()/h
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := b.Min.X + (x+1)*b.Dx()/w
+			if x1 == x0 {
+				x1++
+			}
+			var sum, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					sum += uint64(grayLevel(img, sx, sy))
+					n++
+				}
+			}
+			gray[y][x] = sum / n
+		}
+	}
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if gray[y][x] > gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// grayLevel returns the luminance of a pixel of img, from 0 to 0xffff.
+func grayLevel(img image.Image, x, y int) uint32 {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return (19595*r + 38470*g + 7471*b + 1<<15) >> 16
+}
+
+// contentBounds returns the smallest rectangle of img holding every pixel darker than paper.
+func contentBounds(img image.Image) image.Rectangle {
+	b := img.Bounds()
+	minX, minY, maxX, maxY := b.Max.X, b.Max.Y, b.Min.X, b.Min.Y
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if grayLevel(img, x, y) < blankLevel {
+				if x < minX {
+					minX = x
+				}
+				if x >= maxX {
+					maxX = x + 1
+				}
+				if y < minY {
+					minY = y
+				}
+				maxY = y + 1
+			}
+		}
+	}
+	if maxX <= minX {
+		return image.Rectangle{}
+	}
+	return image.Rectangle{image.Point{minX, minY}, image.Point{maxX, maxY}}
+}
+
+// HammingDistance returns the number of bits in which two hashes differ.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FormatHash returns a hash as 16 hex digits, as stored in manifests.
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// ParseHash parses a hash formatted by FormatHash.
+func ParseHash(s string) (uint64, error) {
+	hash, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hash %q", s)
+	}
+	return hash, nil
+}
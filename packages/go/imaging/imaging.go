@@ -0,0 +1,110 @@
+// Package imaging holds the image helpers of the crawlers: decoding, hashing and thumbnails.
+package imaging
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+)
+
+// Info describes a stored image file.
+type Info struct {
+	File   string // path relative to the directory of the image
+	Format string // "jpeg", "png" or "gif"
+	Width  int
+	Height int
+	SHA256 string // hex encoded hash of the file
+}
+
+// Decode decodes a JPEG, PNG or GIF image and describes it, without File.
+func Decode(data []byte) (image.Image, Info, error) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, Info{}, err
+	}
+	b := img.Bounds()
+	return img, Info{Format: format, Width: b.Dx(), Height: b.Dy(), SHA256: SHA256(data)}, nil
+}
+
+// SHA256 returns the hex encoded SHA-256 hash of data.
+func SHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// FileSHA256 returns the hex encoded SHA-256 hash of a file.
+func FileSHA256(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Thumbnail scales img down to the given width, keeping its aspect ratio.
+// Every pixel of the thumbnail is the average of the pixels it covers.
+// Images not wider than width are returned as they are.
+func Thumbnail(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if width <= 0 || b.Dx() <= width {
+		return img
+	}
+	height := (b.Dy()*width + b.Dx()/2) / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := b.Min.Y + (y+1)*b.Dy()/height
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := b.Min.X + (x+1)*b.Dx()/width
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// WriteJPEG encodes img as JPEG into filename and describes the file, with File set to filename.
+func WriteJPEG(filename string, img image.Image, quality int) (Info, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return Info{}, err
+	}
+	if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		return Info{}, err
+	}
+	b := img.Bounds()
+	return Info{File: filename, Format: "jpeg", Width: b.Dx(), Height: b.Dy(), SHA256: SHA256(buf.Bytes())}, nil
+}
+
+// Describe reads the format and dimensions of a JPEG, PNG or GIF image without decoding its pixels,
+// and hashes it. File is not set.
+func Describe(data []byte) (Info, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Format: format, Width: config.Width, Height: config.Height, SHA256: SHA256(data)}, nil
+}
@@ -0,0 +1,160 @@
+package ipfs
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultGateways are the public IPFS HTTP gateways used when Gateways.URLs is empty.
+var DefaultGateways = []string{"https://ipfs.io", "https://dweb.link", "https://gateway.pinata.cloud"}
+
+// DefaultTimeout is how long a gateway gets to answer when Gateways.Timeout is 0.
+const DefaultTimeout = time.Minute
+
+// Gateways are where the files of a download are fetched from. Every file is requested from the healthiest
+// gateway first, and from the next one whenever a gateway fails or does not answer in time.
+type Gateways struct {
+	// URLs are the base URLs of path gateways, e.g. "https://ipfs.io", serving /ipfs/<CID>.
+	// Defaults to DefaultGateways.
+	URLs []string
+	// API is the RPC API URL of a local node, e.g. "http://127.0.0.1:5001", tried before the gateways if set.
+	API string
+	// Timeout is how long a gateway gets to answer, DefaultTimeout if 0.
+	Timeout time.Duration
+}
+
+func (g Gateways) timeout() time.Duration {
+	if g.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return g.Timeout
+}
+
+// GatewayHealth is what a download learned about a gateway.
+type GatewayHealth struct {
+	URL         string
+	API         bool // URL is the RPC API of a node
+	Successes   int
+	Failures    int // failed requests, timeouts included
+	Timeouts    int
+	MeanLatency time.Duration // of the successful requests
+	Score       float64       // see score
+}
+
+// gateway is a gateway of a download with its health, guarded by the mutex of its pool.
+type gateway struct {
+	GatewayHealth
+	index   int // position in the configuration, to break ties
+	latency time.Duration
+}
+
+// score is the share of successful requests, counting one success and one failure up front so that untried
+// gateways start at 0.5, divided by 1 plus the mean latency in seconds. Slow or failing gateways score lower.
+func (g *gateway) score() float64 {
+	share := float64(g.Successes+1) / float64(g.Successes+g.Failures+2)
+	return share / (1 + g.MeanLatency.Seconds())
+}
+
+// fileURL returns the URL p is requested at, with method.
+func (g *gateway) fileURL(p Path) (method string, link string) {
+	if g.API {
+		return "POST", g.URL + "/api/v0/cat?arg=" + url.QueryEscape("/ipfs/"+p.String())
+	}
+	return "GET", g.URL + "/ipfs/" + p.String()
+}
+
+// pool holds the gateways of a download and which of them served each file.
+type pool struct {
+	mu       sync.Mutex
+	gateways []*gateway
+	servedBy map[string]string
+}
+
+func newPool(g Gateways) *pool {
+	urls := g.URLs
+	if len(urls) == 0 {
+		urls = DefaultGateways
+	}
+	p := &pool{servedBy: make(map[string]string)}
+	if g.API != "" {
+		p.add(strings.TrimSuffix(g.API, "/"), true)
+	}
+	for _, u := range urls {
+		p.add(strings.TrimSuffix(strings.TrimSuffix(u, "/"), "/ipfs"), false)
+	}
+	return p
+}
+
+func (p *pool) add(u string, api bool) {
+	g := &gateway{GatewayHealth: GatewayHealth{URL: u, API: api}, index: len(p.gateways)}
+	g.Score = g.score()
+	p.gateways = append(p.gateways, g)
+}
+
+// order returns the gateways by decreasing score.
+func (p *pool) order() []*gateway {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	order := append([]*gateway{}, p.gateways...)
+	sort.SliceStable(order, func(i, j int) bool {
+		if order[i].Score != order[j].Score {
+			return order[i].Score > order[j].Score
+		}
+		return order[i].index < order[j].index
+	})
+	return order
+}
+
+// succeeded records that g served the file id after latency.
+func (p *pool) succeeded(g *gateway, id string, latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	g.Successes++
+	g.latency += latency
+	g.MeanLatency = g.latency / time.Duration(g.Successes)
+	g.Score = g.score()
+	p.servedBy[id] = g.URL
+}
+
+// failed records that a request to g failed with err.
+func (p *pool) failed(g *gateway, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	g.Failures++
+	if isTimeout(err) {
+		g.Timeouts++
+	}
+	g.Score = g.score()
+}
+
+// health returns the health of the gateways, in the order of the configuration.
+func (p *pool) health() []GatewayHealth {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	health := make([]GatewayHealth, len(p.gateways))
+	for i, g := range p.gateways {
+		health[i] = g.GatewayHealth
+	}
+	return health
+}
+
+// served returns the URL of the gateway that served each file, by id.
+func (p *pool) served() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	served := make(map[string]string, len(p.servedBy))
+	for id, u := range p.servedBy {
+		served[id] = u
+	}
+	return served
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
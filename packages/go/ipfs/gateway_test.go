@@ -0,0 +1,224 @@
+package ipfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/bluemonarch21/matchmaker/crawl"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testCID = "QmXoypizjW3WknFiJnKLwHCnL72vedxjQkDDP1mXWo6uco"
+
+func TestParsePath(t *testing.T) {
+	const v1 = "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+	tests := []struct {
+		ref  string
+		want Path
+	}{
+		{testCID, Path{testCID, ""}},
+		{"/ipfs/" + testCID, Path{testCID, ""}},
+		{"/ipfs/" + testCID + "/score.mscz", Path{testCID, "score.mscz"}},
+		{"ipfs://" + testCID + "/score.mscz", Path{testCID, "score.mscz"}},
+		{"https://ipfs.io/ipfs/" + testCID + "/score.mscz", Path{testCID, "score.mscz"}},
+		{"https://gateway.pinata.cloud/ipfs/" + v1 + "/", Path{v1, ""}},
+		{"https://" + v1 + ".ipfs.dweb.link/score.mscz", Path{v1, "score.mscz"}},
+		{" " + testCID + "\n", Path{testCID, ""}},
+	}
+	for _, test := range tests {
+		got, err := ParsePath(test.ref)
+		if err != nil {
+			t.Errorf("ParsePath(%q) error: %s", test.ref, err)
+		} else if got != test.want {
+			t.Errorf("ParsePath(%q) = %+v, want %+v", test.ref, got, test.want)
+		}
+	}
+
+	for _, ref := range []string{
+		"/ipns/example.com/score.mscz",
+		"ipfs://not-a-cid",
+		"https://ipfs.io/ipfs/",
+		"https://example.com/score.mscz",
+		"",
+	} {
+		if p, err := ParsePath(ref); err == nil {
+			t.Errorf("ParsePath(%q) = %+v, want an error", ref, p)
+		}
+	}
+}
+
+func TestPoolOrder(t *testing.T) {
+	p := newPool(Gateways{URLs: []string{"https://a.example", "https://b.example/", "https://c.example/ipfs"}})
+	names := func() []string {
+		var urls []string
+		for _, g := range p.order() {
+			urls = append(urls, g.URL)
+		}
+		return urls
+	}
+	if got := names(); got[0] != "https://a.example" || got[1] != "https://b.example" || got[2] != "https://c.example" {
+		t.Fatalf("untried gateways: got order %v, want the configuration", got)
+	}
+	a, b, c := p.gateways[0], p.gateways[1], p.gateways[2]
+	p.failed(a, errors.New("500 Internal Server Error"))
+	p.succeeded(c, "1", 100*time.Millisecond)
+	p.succeeded(b, "2", 500*time.Millisecond)
+	// c is fast, b slower, a failing: 0.61, 0.44 and 0.33
+	if got := names(); got[0] != c.URL || got[1] != b.URL || got[2] != a.URL {
+		t.Errorf("got order %v, want c, b, a", got)
+	}
+	if a.Failures != 1 || a.Timeouts != 0 || b.MeanLatency != 500*time.Millisecond {
+		t.Errorf("got health %+v and %+v", a.GatewayHealth, b.GatewayHealth)
+	}
+}
+
+// stubGateway is a path gateway answering every file with status, or with zipFile if status is 200,
+// after delay.
+type stubGateway struct {
+	status  int
+	delay   time.Duration
+	mu      sync.Mutex
+	visited []string
+}
+
+func (s *stubGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.visited = append(s.visited, r.URL.Path)
+	s.mu.Unlock()
+	time.Sleep(s.delay)
+	if s.status != http.StatusOK {
+		w.WriteHeader(s.status)
+		return
+	}
+	w.Write(zipFile)
+}
+
+var zipFile = func() []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, _ := w.Create("score.mscx")
+	f.Write([]byte("<museScore/>"))
+	w.Close()
+	return buf.Bytes()
+}()
+
+// download runs DownloadMuseScore of one file through the gateways at urls, in this order,
+// and returns its output directory and run report.
+func download(t *testing.T, urls []string, timeout time.Duration, politeness crawl.Politeness) (string, *crawl.Report) {
+	t.Helper()
+	dir := t.TempDir()
+	outDir := filepath.Join(dir, "out")
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "mscz-files.csv")
+	if err := os.WriteFile(input, []byte("id,ref\n1,/ipfs/"+testCID+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	politeness.Parallelism = 1
+	DownloadMuseScore(1, outDir, input, Gateways{URLs: urls, Timeout: timeout}, politeness)
+	data, err := os.ReadFile(filepath.Join(dir, "musescore-report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := &crawl.Report{}
+	if err := json.Unmarshal(data, report); err != nil {
+		t.Fatal(err)
+	}
+	return outDir, report
+}
+
+// checkServedBy checks that the file was saved and that the report names served as the gateway that served it.
+func checkServedBy(t *testing.T, outDir string, report *crawl.Report, served *httptest.Server) {
+	t.Helper()
+	if _, err := os.Stat(filepath.Join(outDir, "1.zip")); err != nil {
+		t.Error(err)
+	}
+	servedBy, _ := report.Extra["served by"].(map[string]interface{})
+	if servedBy["1"] != served.URL {
+		t.Errorf("got served by %v, want %s", report.Extra["served by"], served.URL)
+	}
+}
+
+// gatewayHealth returns the health of the gateway at u in report.
+func gatewayHealth(t *testing.T, report *crawl.Report, u string) GatewayHealth {
+	t.Helper()
+	data, _ := json.Marshal(report.Extra["gateways"])
+	var health []GatewayHealth
+	if err := json.Unmarshal(data, &health); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range health {
+		if h.URL == u {
+			return h
+		}
+	}
+	t.Fatalf("no health of %s in %s", u, data)
+	return GatewayHealth{}
+}
+
+func TestDownloadFailoverOnServerError(t *testing.T) {
+	failing := httptest.NewServer(&stubGateway{status: http.StatusInternalServerError})
+	defer failing.Close()
+	working := httptest.NewServer(&stubGateway{status: http.StatusOK})
+	defer working.Close()
+
+	outDir, report := download(t, []string{failing.URL, working.URL}, time.Second, crawl.Politeness{})
+	checkServedBy(t, outDir, report, working)
+	if h := gatewayHealth(t, report, failing.URL); h.Failures != 1 || h.Successes != 0 {
+		t.Errorf("failing gateway: got health %+v", h)
+	}
+	if h := gatewayHealth(t, report, working.URL); h.Successes != 1 || h.Failures != 0 {
+		t.Errorf("working gateway: got health %+v", h)
+	}
+}
+
+func TestDownloadFailoverOnTimeout(t *testing.T) {
+	slow := httptest.NewServer(&stubGateway{status: http.StatusOK, delay: time.Second})
+	defer slow.Close()
+	working := httptest.NewServer(&stubGateway{status: http.StatusOK})
+	defer working.Close()
+
+	outDir, report := download(t, []string{slow.URL, working.URL}, 200*time.Millisecond, crawl.Politeness{})
+	checkServedBy(t, outDir, report, working)
+	if h := gatewayHealth(t, report, slow.URL); h.Timeouts != 1 || h.Failures != 1 {
+		t.Errorf("slow gateway: got health %+v, want one timeout", h)
+	}
+}
+
+func TestDownloadFailoverOnInvalidZip(t *testing.T) {
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>rate limited</html>"))
+	}))
+	defer broken.Close()
+	working := httptest.NewServer(&stubGateway{status: http.StatusOK})
+	defer working.Close()
+
+	outDir, report := download(t, []string{broken.URL, working.URL}, time.Second, crawl.Politeness{})
+	checkServedBy(t, outDir, report, working)
+	if h := gatewayHealth(t, report, broken.URL); h.Successes != 0 || h.Failures != 1 {
+		t.Errorf("gateway serving an invalid zip: got health %+v", h)
+	}
+	if report.Items["bad files"] != 1 || report.Items["files"] != 1 {
+		t.Errorf("got items %v, want one bad file and one file", report.Items)
+	}
+}
+
+func TestDownloadNotFoundIsNotReplayed(t *testing.T) {
+	// a 404 on the only gateway, tried again in a second round
+	missing := &stubGateway{status: http.StatusNotFound}
+	server := httptest.NewServer(missing)
+	defer server.Close()
+
+	download(t, []string{server.URL}, time.Second, crawl.Politeness{MaxRetries: 1, BackoffBase: time.Millisecond})
+	if len(missing.visited) != 2 {
+		t.Errorf("the gateway got %d requests, want 2, the second round must not be answered from a cache", len(missing.visited))
+	}
+}
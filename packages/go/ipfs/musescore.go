@@ -2,14 +2,13 @@ package ipfs
 
 import (
 	"archive/zip"
+	"bytes"
 	"encoding/csv"
-	"errors"
 	"fmt"
+	"github.com/bluemonarch21/matchmaker/crawl"
 	"github.com/gocolly/colly"
 	"io"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
@@ -17,84 +16,107 @@ import (
 	"time"
 )
 
-func DownloadMuseScore(verbose int, outDir string, msczFilePath string, parallelism int) {
+// DownloadMuseScore downloads the zipped scores listed in msczFilePath to outDir from gateways.
+// A JSON run report, with the health of the gateways and the gateway that served each file,
+// is written to musescore-report.json next to outDir.
+// Up to politeness.Parallelism files, 8 by default, are downloaded at once.
+// A file failing on every gateway is tried on all of them again up to politeness.MaxRetries times,
+// after the backoff of politeness, instead of retrying the failed request on the same gateway.
+func DownloadMuseScore(verbose int, outDir string, msczFilePath string, gateways Gateways, politeness crawl.Politeness) {
 	var stdout io.Writer
 	var err error
 	switch verbose {
 	case 0:
 		stdout, err = os.Create("~console-output-msc.log")
-		log.Fatal(err)
+		if err != nil {
+			log.Fatal(err)
+		}
 	default:
 		stdout = os.Stdout
 	}
 
+	// Responses are not cached, a failed one would be replayed by the next round through the gateways
 	c := colly.NewCollector(
-		colly.CacheDir(filepath.Join(outDir, "../cache")),
 		// Turn on/off asynchronous requests
 		colly.Async(false),
 	)
+	// the next round through the gateways requests the same URLs again
+	c.AllowURLRevisit = true
+	c.SetRequestTimeout(gateways.timeout())
 
-	if err := c.Limit(&colly.LimitRule{
-		DomainGlob:  "*",
-		Parallelism: parallelism, // max collectors running
-		//Delay:       2 * time.Second, // delay between each call. If collectors finish before delay, only parallelism=1.
-	}); err != nil {
+	report := crawl.NewReport("musescore")
+	rounds := politeness.MaxRetries
+	politeness.MaxRetries = 0
+	if err := politeness.Apply(c, 8, report); err != nil {
 		log.Fatal(err)
 	}
+	report.Track(c)
+	parallelism := politeness.Parallelism
+	if parallelism == 0 {
+		parallelism = 8
+	}
+	pool := newPool(gateways)
 
 	// Before making a request print "Visiting ..."
 	c.OnRequest(func(r *colly.Request) {
 		fmt.Fprintln(stdout, "c.OnRequest", r.URL.String())
+		r.Ctx.Put(sentKey(r.Ctx), true)
+		r.Ctx.Put("started", time.Now())
 	})
 
-	urlToId := make(map[string]string)
-
-	c.RedirectHandler = func(req *http.Request, via []*http.Request) error {
-		var value string
-		var ok bool
-		for _, r := range via {
-			value, ok = urlToId[r.URL.String()]
-			if ok {
-				urlToId[req.URL.String()] = value
-				break
-			}
-		}
+	// Tries the next gateway
+	c.OnError(func(response *colly.Response, err error) {
+		g, ok := response.Ctx.GetAny("gateway").(*gateway)
 		if !ok {
-			return errors.New(fmt.Sprintf("cannot find old URL %v", via))
+			return
 		}
-		return nil
-	}
+		pool.failed(g, err)
+		id := response.Ctx.Get("id")
+		fmt.Fprintf(stdout, "[%s] %s error: %s\n", id, g.URL, err)
+		if err := requestNext(c, response.Ctx, pool, rounds, politeness, report); err != nil {
+			fmt.Fprintln(stdout, err)
+			report.Error(err)
+		}
+	})
 
-	// Saves returned file
+	// Saves returned file, or tries the next gateway if it is not a valid zip
 	c.OnResponse(func(response *colly.Response) {
 		fmt.Fprintf(stdout, "c.OnResponse %s\n", response.Request.URL.String())
-		id, ok := urlToId[response.Request.URL.String()]
+		id := response.Ctx.Get("id")
+		g, ok := response.Ctx.GetAny("gateway").(*gateway)
 		if !ok {
-			fmt.Fprintf(stdout, "key not found %s\n", response.Request.URL.String())
-			return
-		}
-		zfp := filepath.Join(outDir, fmt.Sprintf("%s.zip", id))
-		if err := response.Save(zfp); err != nil {
-			fmt.Fprintf(stdout, "c.Save %s error: %s\n", response.Request.URL, err)
+			fmt.Fprintf(stdout, "gateway not found %s\n", response.Request.URL.String())
 			return
 		}
-		// Try opening saved file to check validity
-		r, err := zip.OpenReader(zfp)
-		if err != nil {
-			if err := os.MkdirAll(filepath.Join(outDir, "../bad"), 0666); err != nil {
+		if _, err := zip.NewReader(bytes.NewReader(response.Body), int64(len(response.Body))); err != nil {
+			err = fmt.Errorf("%s: invalid zip from %s: %w", id, g.URL, err)
+			fmt.Fprintln(stdout, err)
+			report.Count("bad files", 1)
+			report.Error(err)
+			if err := os.MkdirAll(filepath.Join(outDir, "../bad"), 0755); err != nil {
 				log.Fatal(err)
 			}
-			if err := os.Rename(zfp, filepath.Join(outDir, "../bad", fmt.Sprintf("%s-%s.zip", id, time.Now().Format("YYYY-MM-DD-hh-mm-ss")))); err != nil {
-				if err := os.Remove(zfp); err != nil {
-					log.Fatal(err)
-				}
-				log.Fatal(err)
+			bad := filepath.Join(outDir, "../bad", fmt.Sprintf("%s-%s.zip", id, time.Now().Format("2006-01-02-15-04-05")))
+			if err := response.Save(bad); err != nil {
+				fmt.Fprintf(stdout, "c.Save %s error: %s\n", response.Request.URL, err)
 			}
+			pool.failed(g, err)
+			if err := requestNext(c, response.Ctx, pool, rounds, politeness, report); err != nil {
+				fmt.Fprintln(stdout, err)
+				report.Error(err)
+			}
+			return
 		}
-		if err := r.Close(); err != nil {
-			log.Fatal(err)
+		started, _ := response.Ctx.GetAny("started").(time.Time)
+		pool.succeeded(g, id, time.Since(started))
+		zfp := filepath.Join(outDir, fmt.Sprintf("%s.zip", id))
+		if err := response.Save(zfp); err != nil {
+			fmt.Fprintf(stdout, "c.Save %s error: %s\n", response.Request.URL, err)
+			report.Error(err)
+			return
 		}
-		fmt.Fprintf(stdout, "Valid %s\n", zfp)
+		report.Count("files", 1)
+		fmt.Fprintf(stdout, "Valid %s from %s\n", zfp, g.URL)
 	})
 
 	matches, _ := filepath.Glob(fmt.Sprintf("%s/*.zip", outDir))
@@ -105,9 +127,9 @@ func DownloadMuseScore(verbose int, outDir string, msczFilePath string, parallel
 		existingIds[i] = parts[0]
 	}
 
-	urls := make(chan struct {
-		url string
-		id  string
+	files := make(chan struct {
+		path Path
+		id   string
 	}, parallelism)
 	done := make(chan bool, 1)
 	limit := make(chan bool, parallelism)
@@ -134,20 +156,16 @@ func DownloadMuseScore(verbose int, outDir string, msczFilePath string, parallel
 				if len(existingIds) > 0 && i != len(existingIds) && existingIds[i] == id {
 					continue
 				}
-				ref := records[1]
-				ipns := ref[6:]
-				u := fmt.Sprintf("https://ipfs.infura.io/ipfs/%s/", ipns)
-				parsedUrl, err := url.Parse(u)
+				p, err := ParsePath(records[1])
 				if err != nil {
-					fmt.Fprintln(stdout, "BAD URL", u)
+					fmt.Fprintln(stdout, "BAD REF", records[1])
+					report.Error(fmt.Errorf("%s: %w", id, err))
 					continue
 				}
-				u = parsedUrl.String()
-				urlToId[u] = id
-				urls <- struct {
-					url string
-					id  string
-				}{u, id}
+				files <- struct {
+					path Path
+					id   string
+				}{p, id}
 			} else if err == io.EOF {
 				break
 			} else {
@@ -155,21 +173,84 @@ func DownloadMuseScore(verbose int, outDir string, msczFilePath string, parallel
 				break
 			}
 		}
-		done <- true
+		close(files)
 	}()
 
 	go func() {
-		for pair := range urls {
+		for file := range files {
 			<-limit
-			go func(u string, id string) {
-				fmt.Fprintf(stdout, "\n[%s]\nc Visiting %s\n", id, u)
-				if err := c.Visit(u); err != nil {
-					fmt.Fprintf(stdout, "c.Visiting error: %s\n", err)
+			go func(p Path, id string) {
+				fmt.Fprintf(stdout, "\n[%s]\nc Visiting %s\n", id, p)
+				ctx := colly.NewContext()
+				ctx.Put("id", id)
+				ctx.Put("path", p)
+				ctx.Put("order", pool.order())
+				ctx.Put("attempt", -1)
+				ctx.Put("round", 0)
+				// failures are reported once every gateway failed, see requestNext
+				if err := requestNext(c, ctx, pool, rounds, politeness, report); err != nil {
+					fmt.Fprintln(stdout, err)
+					report.Error(err)
 				}
 				limit <- true
-			}(pair.url, pair.id)
+			}(file.path, file.id)
+		}
+		// wait for the last files
+		for i := 0; i < parallelism; i++ {
+			<-limit
 		}
+		done <- true
 	}()
 	<-done
 	c.Wait()
+	report.Count("skipped files", len(existingIds))
+	report.Set("gateways", pool.health())
+	report.Set("served by", pool.served())
+	if err := report.WriteFile(filepath.Join(outDir, "../musescore-report.json")); err != nil {
+		log.Println("report.WriteFile error:", err)
+	}
+}
+
+// requestNext requests the file of ctx from the gateway after the one of the last attempt.
+// After the last gateway it starts another round through the gateways, ordered by their current health,
+// after waiting the backoff of politeness, up to rounds times. It returns an error once every round failed.
+// Failing requests call requestNext again from the OnError callback of c.
+func requestNext(c *colly.Collector, ctx *colly.Context, pool *pool, rounds int, politeness crawl.Politeness, report *crawl.Report) error {
+	id := ctx.Get("id")
+	p := ctx.GetAny("path").(Path)
+	order := ctx.GetAny("order").([]*gateway)
+	attempt := ctx.GetAny("attempt").(int)
+	for {
+		attempt++
+		if attempt == len(order) {
+			round := ctx.GetAny("round").(int) + 1
+			if round > rounds {
+				return fmt.Errorf("%s: %s failed on every gateway", id, p)
+			}
+			report.Retry()
+			time.Sleep(politeness.Backoff(round, nil))
+			ctx.Put("round", round)
+			order = pool.order()
+			ctx.Put("order", order)
+			attempt = 0
+		}
+		ctx.Put("attempt", attempt)
+		g := order[attempt]
+		ctx.Put("gateway", g)
+		method, link := g.fileURL(p)
+		sent := sentKey(ctx)
+		err := c.Request(method, link, nil, ctx, nil)
+		if err == nil || ctx.GetAny(sent) != nil {
+			// sent, OnResponse or OnError took over
+			return nil
+		}
+		// not sent, e.g. an invalid gateway URL
+		pool.failed(g, err)
+		report.Error(fmt.Errorf("%s: %s: %w", id, g.URL, err))
+	}
+}
+
+// sentKey returns the context key telling that the request of the current attempt of ctx was sent.
+func sentKey(ctx *colly.Context) string {
+	return fmt.Sprintf("sent %v %v", ctx.GetAny("round"), ctx.GetAny("attempt"))
 }
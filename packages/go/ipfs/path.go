@@ -0,0 +1,96 @@
+package ipfs
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Path is the path of a file in IPFS, a CID followed by the path of the file in the directory it names, if any.
+type Path struct {
+	CID  string
+	Rest string // e.g. "score.mscz", "" if the CID names the file
+}
+
+func (p Path) String() string {
+	if p.Rest == "" {
+		return p.CID
+	}
+	return p.CID + "/" + p.Rest
+}
+
+const (
+	base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	base32Alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+	base36Alphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+	base16Alphabet = "0123456789abcdef"
+)
+
+// multibases are the alphabets of the multibase prefixes of CIDv1 strings.
+var multibases = map[byte]string{
+	'b': base32Alphabet,
+	'B': strings.ToUpper(base32Alphabet),
+	'z': base58Alphabet,
+	'k': base36Alphabet,
+	'K': strings.ToUpper(base36Alphabet),
+	'f': base16Alphabet,
+	'F': strings.ToUpper(base16Alphabet),
+}
+
+// ValidCID tells whether s is a CIDv0, e.g. "QmXoypizjW3WknFiJnKLwHCnL72vedxjQkDDP1mXWo6uco",
+// or a CIDv1 in one of the common multibase encodings, e.g. "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi".
+// Only the characters are checked, not the multihash they encode.
+func ValidCID(s string) bool {
+	if len(s) == 46 && strings.HasPrefix(s, "Qm") {
+		return onlyOf(s, base58Alphabet)
+	}
+	if len(s) < 9 {
+		return false
+	}
+	alphabet, ok := multibases[s[0]]
+	return ok && onlyOf(s[1:], alphabet)
+}
+
+func onlyOf(s string, alphabet string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune(alphabet, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParsePath parses a reference to a file in IPFS, given as a path, e.g. "/ipfs/<CID>/score.mscz",
+// an ipfs:// URL, a gateway URL, e.g. "https://ipfs.io/ipfs/<CID>" or "https://<CID>.ipfs.dweb.link/",
+// or a bare CID. IPNS names are not supported, their content may change.
+func ParsePath(ref string) (Path, error) {
+	s := strings.TrimSpace(ref)
+	if strings.HasPrefix(s, "ipfs://") {
+		s = strings.TrimPrefix(s, "ipfs://")
+	} else if strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return Path{}, fmt.Errorf("invalid IPFS reference %q: %w", ref, err)
+		}
+		if labels := strings.SplitN(u.Hostname(), ".", 3); len(labels) == 3 && labels[1] == "ipfs" && ValidCID(labels[0]) {
+			// subdomain gateway
+			s = labels[0] + u.Path
+		} else {
+			s = u.Path
+		}
+	}
+	s = strings.Trim(s, "/")
+	if strings.HasPrefix(s, "ipns/") {
+		return Path{}, fmt.Errorf("unsupported IPNS reference %q", ref)
+	}
+	s = strings.TrimPrefix(s, "ipfs/")
+	parts := strings.SplitN(s, "/", 2)
+	if !ValidCID(parts[0]) {
+		return Path{}, fmt.Errorf("invalid CID in IPFS reference %q", ref)
+	}
+	p := Path{CID: parts[0]}
+	if len(parts) == 2 {
+		p.Rest = parts[1]
+	}
+	return p, nil
+}
@@ -5,16 +5,23 @@ import (
 	"context"
 	"encoding/csv"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"github.com/bluemonarch21/matchmaker/crawl"
 	"github.com/bluemonarch21/matchmaker/henle"
 	"github.com/bluemonarch21/matchmaker/ipfs"
+	"github.com/bluemonarch21/matchmaker/server"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -26,11 +33,49 @@ func scrapeToFile() {
 	}
 	defer outFile.Close()
 
-	henle.ScrapeBookDetails("csv", 1, outFile, nil)
+	sink, err := henle.NewCSVSink(outFile, henle.DetailRows, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := henle.ScrapeBookDetails(1, henle.CrawlOptions{}, sink); err != nil {
+		log.Println(err)
+	}
 }
 
 func scrapeToStdout() {
-	henle.ScrapeBookDetails("json", 0, os.Stdout, nil)
+	if err := henle.ScrapeBookDetails(0, henle.CrawlOptions{}, henle.NewJSONSink(os.Stdout)); err != nil {
+		log.Println(err)
+	}
+}
+
+// searchQueries collects repeated --query flags.
+type searchQueries []henle.SearchQuery
+
+func (q *searchQueries) String() string {
+	return fmt.Sprint(*q)
+}
+
+func (q *searchQueries) Set(value string) error {
+	query, err := henle.ParseSearchQuery(value)
+	if err != nil {
+		return err
+	}
+	*q = append(*q, query)
+	return nil
+}
+
+// politenessFlags defines the flags of crawl.Politeness on flags, defaulting to crawl.DefaultPoliteness.
+func politenessFlags(flags *flag.FlagSet) *crawl.Politeness {
+	p := crawl.DefaultPoliteness
+	flags.DurationVar(&p.Delay, "delay", p.Delay, "delay after each request")
+	flags.DurationVar(&p.RandomDelay, "random-delay", p.RandomDelay, "max random delay added to --delay")
+	flags.IntVar(&p.Parallelism, "parallelism", p.Parallelism, "max requests running at once, 0 for the crawler's default")
+	flags.StringVar(&p.UserAgent, "user-agent", p.UserAgent, "user agent")
+	flags.BoolVar(&p.RespectRobotsTxt, "robots-txt", p.RespectRobotsTxt, "respect robots.txt")
+	flags.IntVar(&p.MaxRetries, "max-retries", p.MaxRetries, "retries of requests failing with 429, 5xx or a network error")
+	flags.DurationVar(&p.BackoffBase, "backoff", p.BackoffBase, "wait before the first retry, doubled every retry")
+	flags.DurationVar(&p.BackoffMax, "max-backoff", p.BackoffMax, "max wait between retries, unless Retry-After asks for longer")
+	return &p
 }
 
 type Piece struct {
@@ -87,7 +132,8 @@ func testMongo() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 	err = client.Connect(ctx)
 	if err != nil {
 		log.Fatal(err)
@@ -224,6 +270,59 @@ func readJSONL(filename string, collection *mongo.Collection) error {
 	return nil
 }
 
+// parsePage parses a saved Henle page of the given kind and writes it to w as indented JSON.
+func parsePage(kind string, filename string, rawURL string, w io.Writer) error {
+	pageURL, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var v interface{}
+	switch kind {
+	case "details":
+		v, err = henle.ParseBookDetail(file, pageURL)
+	case "search":
+		v, err = henle.ParseSearchResults(file, pageURL)
+	case "person":
+		v, err = henle.ParsePerson(file, pageURL)
+	case "pageflip":
+		v, err = henle.ParsePageflip(file)
+	case "titles":
+		v, err = parseTitles(file)
+	default:
+		return fmt.Errorf("invalid page %q", kind)
+	}
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// TitleWork is a piece title with the WorkInfo parsed from it.
+type TitleWork struct {
+	Title string
+	Work  henle.WorkInfo
+}
+
+// parseTitles parses every line of r as the title of a piece.
+func parseTitles(r io.Reader) ([]TitleWork, error) {
+	var titles []TitleWork
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if title := strings.TrimSpace(scanner.Text()); title != "" {
+			titles = append(titles, TitleWork{title, henle.ParseWorkTitle(title)})
+		}
+	}
+	return titles, scanner.Err()
+}
+
 const helpMsg string = `
 usage: <exe> <command> [arguments]
 
@@ -233,11 +332,17 @@ The commands are:
 
         crawl       start the web crawler on Henle.de search results page
         download    start the IPFS donwloader for mscz-files.csv
+        parse       parse a saved Henle.de page and print it as JSON
+        pdf         assemble the downloaded Henle.de preview pages into a PDF per book
+        iiif        write IIIF Presentation manifests of the downloaded Henle.de preview pages
+        serve       start the server, serving the IIIF manifests and images
+        duplicates  find near-duplicate preview pages and covers across Henle.de books
+        notation    find the staff systems of the downloaded Henle.de preview pages
 
 Use "<exe> help <command>" for more information about a command.`
 
 const helpCrawlMsg string = `
-usage: <exe> crawl <destination> [--mode [csv|json]] [--out-dir <path/to/dir>]
+usage: <exe> crawl <destination> [--mode [csv|json|csv,json]] [--out-dir <path/to/dir>] [search flags]
 
 Start the web crawler on https://www.henle.de/en/search/ search results page.
 
@@ -249,24 +354,278 @@ The available destinations are:
 					and more.
 		images
 					scrapes the book preview images https://www.henle.de/pageflip
-					if available.
+					if available, into <out-dir>/henle/<HN>/<width>/<page>.jpg.
+					The separate previews of the parts of chamber editions go to
+					<out-dir>/henle/<HN>/<voice>/<width>/<page>.jpg. Voices without a
+					page path are counted and listed as errors in the run report.
+					The voice names and the pages of each book, with their dimensions,
+					size and SHA-256, are listed in <out-dir>/henle/<HN>/manifest.json.
 					Default image width is 1500.
+		contributors
+					scrapes the book details pages, then the profile pages of their
+					composers, editors and fingering authors into henle-persons.jsonl.
+					Information includes name, life dates, nationality, biography
+					and the HN numbers of their editions.
 
 The flags are:
 
         --mode
 					specify output file format.
+					Formats can be combined with a comma to write both files.
+					The CSV output has a header row and fixed columns, see henle.CSVSchemaVersion.
+					Contributors are also written to henle-contributors.csv, joined by the hn column.
+					Only valid for details scraping.
+        --csv-layout
+					"detail" writes one row per piece and movement (default),
+					"book" writes one row per book with its sections, pieces
+					and movements nested as JSON.
 					Only valid for details scraping.
         --out-dir
 					specify output directory.
 					Only valid for images scraping.
-        [TBA]
+        --widths
+					comma separated width variants of the preview pages, "w500" and/or
+					"w1500", as given by pagePath and pagePathZoom of the pageflip.
+					Default is w1500.
+					Only valid for images scraping.
+        --hn
+					comma separated HNs and HN ranges, e.g. "650,1400-1410", whose
+					preview pages are downloaded instead of those of the search results.
+					Only valid for images scraping.
+        --skip-existing
+					do not download the pages listed in the manifest of their book
+					whose file still has the recorded size and SHA-256.
+					Only valid for images scraping.
+        --difficulty
+					only write the pieces and movements with a part of the given
+					instrument and Henle difficulty, e.g. "Violin:5-6" or "Piano:4".
+					"Violin" also matches the parts "Violin I" and "Violin II".
+					Books without such a piece are left out.
+					crawl has no MongoDB output, the filter applies to the JSON and
+					CSV files of --mode only.
+					Only valid for details scraping, the change log of --since is not filtered.
+        --covers-dir
+					download the cover of every book to <covers-dir>/henle/<HN>/cover.jpg
+					and record its file, dimensions and SHA-256 in the book. The cover
+					metadata is kept in cover.json next to it, covers whose stored hash
+					is still current are not written again.
+					By default covers are not downloaded.
+					Only valid for details scraping.
+        --thumbnails
+					comma separated widths of the JPEG thumbnails saved next to each
+					cover as cover-w<width>.jpg, default is "200,400". Empty for none.
+					Only valid with --covers-dir.
+        --langs
+					comma separated languages, e.g. "de,fr", of which the detail pages
+					are also scraped and merged into each book by HN.
+					Only valid for details scraping.
+        --since
+					previous JSON output, e.g. a copy of henle-books.json, to compare
+					the scraped books against by HN. Added, removed and modified books
+					are written to the change log, and every new version of a book is
+					appended to <history-dir>/<HN>.jsonl.
+					Only valid for details scraping of the full result set, not with
+					--max-pages or --resume.
+        --changes
+					change log file, default is henle-changes.jsonl.
+        --history-dir
+					history directory, default is henle-history.
+        --cache-dir
+					directory the responses are cached in, default is ../../cache.
+        --state
+					file the crawl state is written to while crawling: visited and
+					queued URLs, and the HNs of completed books.
+					Default is henle-<destination>-state.jsonl.
+					Only valid for details and images scraping.
+        --resume
+					continue the crawl of the state file where it stopped, instead of
+					starting over. Completed books are not scraped again, so with
+					--mode the output files only hold the books of this run.
+        --report
+					JSON run report file, default is henle-details-report.json
+					or henle-images-report.json. The contributors crawl also writes
+					the report of the profile pages with a "-persons" suffix.
+
+The search flags are:
+
+        --scoring, --instrument, --composer, --text, --lang
+					build one search query, e.g.
+					--scoring "String instruments" --instrument "Violin and Piano".
+        --query
+					add a search query, either as a henle.de search URL or as
+					"scoring=...&instrument=...&composer=...&text=...&lang=...".
+					Can be given more than once.
+					Books found by more than one query are only scraped once.
+        --max-pages
+					limit the number of search results pages followed per query.
+					By default all pages are followed.
+` + helpPolitenessMsg + `
+
+When no search flag is given, the piano solo search is crawled.
 
 For more control, import the library's function to use directly.
 See package github.com/bluemonarch21/matchmaker/henle for more information.`
 
+const helpPolitenessMsg string = `
+The politeness flags are:
+
+        --delay, --random-delay
+					wait after each request, e.g. "2s", plus a random duration up to
+					--random-delay. Default is 500ms each.
+        --parallelism
+					max requests running at once. Default is 2 for henle.de and 8 for IPFS.
+        --user-agent
+					user agent sent with every request.
+        --robots-txt
+					respect robots.txt of the visited sites.
+        --max-retries
+					retries of requests failing with 429, 5xx or a network error, default is 3.
+        --backoff, --max-backoff
+					the first retry waits --backoff (default 1s), doubled every retry
+					up to --max-backoff (default 1m). A longer Retry-After is honored.`
+
+const helpParseMsg string = `
+usage: <exe> parse <page> <path/to/page.html> [--url <page URL>]
+
+Parse a saved page of https://www.henle.de/ without visiting the site, and print the result as JSON.
+
+The available pages are:
+
+		details
+					a book details page https://www.henle.de/en/detail/.
+		search
+					a search results page https://www.henle.de/en/search/.
+		person
+					a composer or contributor profile page.
+		pageflip
+					a preview page https://www.henle.de/en/detail/?pageflip=<HN>,
+					printing the payload of its Pageflip.init call.
+		titles
+					a text file of piece titles, one per line, parsed with henle.ParseWorkTitle.
+
+The flags are:
+
+        --url
+					the URL the page was saved from, used to resolve relative links.
+					Default is https://www.henle.de/en/.
+
+The golden files in henle/testdata are the output of this command for the HTML files next to them,
+and titles.json for the corpus of real Henle piece titles in titles.txt.`
+
+const helpPDFMsg string = `
+usage: <exe> pdf [--out-dir <path/to/dir>] [--width w1500] [--hn <HNs>] [--books <path/to/henle-books.json>]
+
+Assemble the preview pages saved by "crawl images" in <out-dir>/henle/<HN>/<width>/ into
+<out-dir>/henle/<HN>/<HN>-<width>.pdf, one page per image in page order.
+The PDF title and author are the title and composer of the book, the HN is in the subject and keywords.
+
+The flags are:
+
+        --out-dir
+					directory given to "crawl images", default is data.
+        --width
+					width variant of the pages, default is w1500.
+        --hn
+					comma separated HNs and HN ranges, e.g. "650,1400-1410".
+					By default every downloaded book is assembled.
+        --books
+					JSON output of "crawl details", e.g. henle-books.json, to take the
+					title and composer of the books from. Without it the title is the HN.`
+
+const helpIIIFMsg string = `
+usage: <exe> iiif [--out-dir <path/to/dir>] [--base-url <URL>] [--hn <HNs>] [--books <path/to/henle-books.json>]
+
+Write an IIIF Presentation 3.0 manifest of the preview pages saved by "crawl images" to
+<out-dir>/henle/<HN>/iiif-manifest.json, one canvas per page. Books without a manifest.json are skipped.
+The manifests and pages are served by "<exe> serve" as:
+
+        <base-url>/iiif/henle/manifests/<HN>
+        <base-url>/iiif/henle/images/<HN>-<page>/info.json
+        <base-url>/iiif/henle/images/<HN>-<page>/full/max/0/default.jpg
+
+The images follow the IIIF Image API 3.0 at level 0: only the full region, unrotated, in the sizes
+of the downloaded width variants, e.g. full/500,/0/default.jpg for w500, are available.
+
+The flags are:
+
+        --out-dir
+					directory given to "crawl images", default is data.
+        --base-url
+					URL of the ids in the written files, default is http://localhost:8080.
+					"<exe> serve" replaces it with the URL each request is sent to,
+					as it does in the info.json of the images.
+        --hn
+					comma separated HNs and HN ranges, e.g. "650,1400-1410".
+					By default the manifests of every downloaded book are written.
+        --books
+					JSON output of "crawl details", e.g. henle-books.json, to take the
+					title, composer and other metadata of the books from.`
+
+const helpServeMsg string = `
+usage: <exe> serve [--addr :8080] [--data-dir <path/to/dir>]
+
+Start the server. The IIIF manifests written by "<exe> iiif" and the preview pages under
+<data-dir>/henle are served, see "<exe> help iiif".
+
+The flags are:
+
+        --addr
+					address to listen on, default is :8080.
+        --data-dir
+					directory given to "crawl images", default is data.`
+
+const helpDuplicatesMsg string = `
+usage: <exe> duplicates [--out-dir <path/to/dir>] [--covers-dir <path/to/dir>] [--hn <HNs>] [--distance 4] [--report <path/to/file>]
+
+Compute the perceptual hash (dHash) of every preview page saved by "crawl images" and store it in
+<out-dir>/henle/<HN>/manifest.json, and of every cover saved by "crawl details --covers-dir" and store
+it in <covers-dir>/henle/<HN>/cover.json. Images hashed before are not decoded again.
+Then report the clusters of near-duplicate images spanning more than one book, e.g. the pages of an
+anthology engraved like those of a single-work edition, to <out-dir>/henle-duplicates.json.
+Blank pages are left out, and so are pages that cannot be decoded, which are listed in the output.
+
+The flags are:
+
+        --out-dir
+					directory given to "crawl images", default is data.
+        --covers-dir
+					directory given to "crawl details --covers-dir". Covers are not
+					compared without it.
+        --hn
+					comma separated HNs and HN ranges, e.g. "650,1400-1410".
+					By default every downloaded book is compared.
+        --distance
+					largest number of differing bits between the 64 bit hash of an
+					image and that of the first image of its cluster, default is 4.
+        --report
+					file the clusters are written to as JSON, default is
+					<out-dir>/henle-duplicates.json.`
+
+const helpNotationMsg string = `
+usage: <exe> notation [--out-dir <path/to/dir>] [--width w1500] [--hn <HNs>] [--out <path/to/file>]
+
+Find the staff systems of the preview pages saved by "crawl images", from the images alone, and write
+one JSON line per page, keyed by HN, voice and page, to <out-dir>/henle-notation.jsonl. For every system
+the line holds its position, number of staves, staff spacing and ink density, i.e. the part of its
+pixels that are dark, staff lines left out. The layout of the page is "solo" for one staff per system,
+"grand" for two, as for piano, and "score" for more.
+Pages that cannot be read or decoded are listed in the output and left out.
+
+The flags are:
+
+        --out-dir
+					directory given to "crawl images", default is data.
+        --width
+					width variant of the pages, default is w1500.
+        --hn
+					comma separated HNs and HN ranges, e.g. "650,1400-1410".
+					By default every downloaded book is analysed.
+        --out
+					file the JSON lines are written to, default is
+					<out-dir>/henle-notation.jsonl.`
+
 const helpDownloadMsg string = `
-usage: <exe> download <destination> --out-dir <path/to/dir> --from <path/to/input/file>
+usage: <exe> download <destination> --out-dir <path/to/dir> --from <path/to/input/file> [--gateways <URLs>] [--ipfs-api <URL>]
 
 Start the IPFS downloader from input file.
 
@@ -277,6 +636,28 @@ The available destinations are:
 					Works along side https://github.com/Xmader/musescore-dataset from
 					which mscz-files.csv can be downloaded. 
 
+The flags are:
+
+        --out-dir
+					directory the zip files are saved in. Files already in it are skipped.
+        --from
+					input file.
+        --gateways
+					comma separated IPFS path gateways the files are requested from,
+					default is https://ipfs.io,https://dweb.link,https://gateway.pinata.cloud.
+					Every file is requested from the healthiest gateway first and from the
+					next one when it fails or times out. Slow or failing gateways are tried
+					later. The run report records the health of every gateway and which
+					gateway served each file.
+        --ipfs-api
+					RPC API URL of a local IPFS node, e.g. http://127.0.0.1:5001,
+					tried before the gateways.
+        --timeout
+					how long a gateway gets to answer, default is 1m.
+` + helpPolitenessMsg + `
+With download, --max-retries is the number of times a file failing on every gateway is tried
+on all of them again, --backoff and --max-backoff are waited between these rounds.
+
 For more control, import the library's function to use directly.
 See package github.com/bluemonarch21/matchmaker/ipfs for more information.`
 
@@ -303,77 +684,335 @@ func main() {
 	//r := server.SetupRouter()
 	//// Listen and Server in 0.0.0.0:8080
 	//r.Run(":8080")
-	command := os.Args[0]
+	if len(os.Args) < 2 {
+		fmt.Println(helpMsg)
+		return
+	}
+	command := os.Args[1]
 	if command == "crawl" {
-		destination := os.Args[1]
+		if len(os.Args) < 3 {
+			fmt.Println(helpCrawlMsg)
+			log.Fatal("Missing destination")
+		}
+		destination := os.Args[2]
+		flags := flag.NewFlagSet("crawl "+destination, flag.ExitOnError)
+		flags.Usage = func() { fmt.Println(helpCrawlMsg) }
+		mode := flags.String("mode", "csv", "output file format")
+		csvLayout := flags.String("csv-layout", "detail", "rows of the CSV output, detail or book")
+		outDir := flags.String("out-dir", "data", "output directory")
+		var single henle.SearchQuery
+		flags.StringVar(&single.Scoring, "scoring", "", "search scoring")
+		flags.StringVar(&single.Instrument, "instrument", "", "search instrument")
+		flags.StringVar(&single.Composer, "composer", "", "search composer")
+		flags.StringVar(&single.Text, "text", "", "search free text")
+		flags.StringVar(&single.Language, "lang", "", "search language")
+		var queries searchQueries
+		flags.Var(&queries, "query", "search query, can be repeated")
+		maxPages := flags.Int("max-pages", 0, "max search results pages per query")
+		langs := flags.String("langs", "", "comma separated languages of detail pages to merge")
+		since := flags.String("since", "", "previous JSON output to compare against")
+		changes := flags.String("changes", "henle-changes.jsonl", "change log file")
+		historyDir := flags.String("history-dir", "henle-history", "directory of per HN history files")
+		reportFile := flags.String("report", "", "run report file")
+		difficulty := flags.String("difficulty", "", "only write pieces of instrument:level, e.g. Violin:5-6")
+		widths := flags.String("widths", "w1500", "comma separated pageflip width variants, e.g. w500,w1500")
+		hns := flags.String("hn", "", "comma separated HNs or HN ranges to download instead of searching")
+		skipExisting := flags.Bool("skip-existing", false, "skip pages whose file matches the manifest")
+		coversDir := flags.String("covers-dir", "", "directory the covers are downloaded to")
+		thumbnails := flags.String("thumbnails", "200,400", "comma separated widths of the cover thumbnails")
+		politeness := politenessFlags(flags)
+		cacheDir := flags.String("cache-dir", "../../cache", "colly cache directory")
+		stateFile := flags.String("state", "henle-"+destination+"-state.jsonl", "crawl state file")
+		resume := flags.Bool("resume", false, "continue the crawl of the state file")
+		if err := flags.Parse(os.Args[3:]); err != nil {
+			log.Fatal(err)
+		}
+		if single != (henle.SearchQuery{}) {
+			queries = append(searchQueries{single}, queries...)
+		}
+		opts := henle.CrawlOptions{
+			Queries:    queries,
+			MaxPages:   *maxPages,
+			ReportFile: *reportFile,
+			Politeness: *politeness,
+			CacheDir:   *cacheDir,
+		}
+		if destination == "details" || destination == "images" {
+			state, err := crawl.OpenState(*stateFile, *resume)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer state.Close()
+			opts.State = state
+		}
+		if *langs != "" {
+			opts.Languages = strings.Split(*langs, ",")
+		}
+		opts.Images.Widths = strings.Split(*widths, ",")
+		opts.Images.SkipExisting = *skipExisting
+		if *hns != "" {
+			list, err := henle.ParseHNs(*hns)
+			if err != nil {
+				fmt.Println(helpCrawlMsg)
+				log.Fatal(err)
+			}
+			opts.Images.HNs = list
+		}
+		opts.Covers.Dir = *coversDir
+		for _, w := range strings.Split(*thumbnails, ",") {
+			if w = strings.TrimSpace(w); w == "" {
+				continue
+			}
+			width, err := strconv.Atoi(w)
+			if err != nil || width <= 0 {
+				fmt.Println(helpCrawlMsg)
+				log.Fatal("Invalid thumbnail width ", w)
+			}
+			opts.Covers.ThumbnailWidths = append(opts.Covers.ThumbnailWidths, width)
+		}
+
 		if destination == "details" {
-			var mode string
-			if len(os.Args) == 2 {
-				mode = "csv"
-			} else if os.Args[2] != "--mode" {
+			var filter *henle.DifficultyFilter
+			if *difficulty != "" {
+				f, err := henle.ParseDifficultyFilter(*difficulty)
+				if err != nil {
+					fmt.Println(helpCrawlMsg)
+					log.Fatal(err)
+				}
+				filter = &f
+			}
+			var sinks []henle.BookSink
+			if *since != "" && (*maxPages != 0 || *resume) {
+				// books not scraped by this run would be recorded as removed
 				fmt.Println(helpCrawlMsg)
-				log.Fatal("Invalid argument at 2")
-			} else {
-				mode = os.Args[3]
+				log.Fatal("--since needs the full result set, it cannot be used with --max-pages or --resume")
 			}
-			var filename string
-			if mode == "csv" {
-				filename = "henle-books.csv"
-			} else if mode == "json" {
-				filename = "henle-books.json"
+			if *since != "" {
+				// Load before the output files are created, they may be the same file
+				previous, err := henle.LoadSnapshotFile(*since)
+				if err != nil {
+					log.Fatal(err)
+				}
+				changelog, err := os.Create(*changes)
+				if err != nil {
+					log.Fatal(err)
+				}
+				defer changelog.Close()
+				sinks = append(sinks, henle.NewIncrementalSink(previous, changelog, *historyDir))
 			}
-			f, err := os.Open(filename)
+			for _, m := range strings.Split(*mode, ",") {
+				var filename string
+				if m == "csv" {
+					filename = "henle-books.csv"
+				} else if m == "json" {
+					filename = "henle-books.json"
+				} else {
+					fmt.Println(helpCrawlMsg)
+					log.Fatal("Invalid mode ", m)
+				}
+				f, err := os.Create(filename)
+				if err != nil {
+					log.Fatal(err)
+				}
+				defer f.Close()
+				var sink henle.BookSink
+				if m == "csv" {
+					layout, err := henle.ParseCSVLayout(*csvLayout)
+					if err != nil {
+						fmt.Println(helpCrawlMsg)
+						log.Fatal(err)
+					}
+					contributors, err := os.Create("henle-contributors.csv")
+					if err != nil {
+						log.Fatal(err)
+					}
+					defer contributors.Close()
+					if sink, err = henle.NewCSVSink(f, layout, contributors); err != nil {
+						log.Fatal(err)
+					}
+				} else {
+					sink = henle.NewJSONSink(f)
+				}
+				if filter != nil {
+					// the change log always compares the full books
+					sink = henle.NewFilterSink(*filter, sink)
+				}
+				sinks = append(sinks, sink)
+			}
+			if err := henle.ScrapeBookDetails(0, opts, sinks...); err != nil {
+				log.Println(err)
+			}
+		} else if destination == "images" {
+			henle.ScrapeBookImages(0, *outDir, opts)
+		} else if destination == "contributors" {
+			f, err := os.Create("henle-persons.jsonl")
 			if err != nil {
 				log.Fatal(err)
 			}
-			henle.ScrapeBookDetails(mode, 0, f, nil)
-		} else if destination == "images" {
-			var outDir string
-			if len(os.Args) == 2 {
-				outDir = "data"
-			} else if os.Args[2] != "--out-dir" {
-				fmt.Println(helpCrawlMsg)
-				log.Fatal("Invalid argument at 2")
-			} else {
-				outDir = os.Args[3]
+			defer f.Close()
+			if err := henle.ScrapeContributors(0, opts, f); err != nil {
+				log.Println(err)
 			}
-			henle.ScrapeBookImages(0, outDir)
 		} else {
 			fmt.Println(helpCrawlMsg)
-			log.Fatal("Invalid argument at 1")
+			log.Fatal("Invalid destination ", destination)
 		}
-	} else if command == "download download <destination> --out-dir <path/to/dir> --from <path/to/input/file>" {
-		if len(os.Args) != 5 {
-			log.Fatal("Invalid argument number 5")
+	} else if command == "parse" {
+		if len(os.Args) < 4 {
+			fmt.Println(helpParseMsg)
+			log.Fatal("Missing arguments")
 		}
-		if os.Args[1] != "musescore" {
+		flags := flag.NewFlagSet("parse", flag.ExitOnError)
+		flags.Usage = func() { fmt.Println(helpParseMsg) }
+		rawURL := flags.String("url", "https://www.henle.de/en/", "page URL")
+		if err := flags.Parse(os.Args[4:]); err != nil {
+			log.Fatal(err)
+		}
+		if err := parsePage(os.Args[2], os.Args[3], *rawURL, os.Stdout); err != nil {
+			fmt.Println(helpParseMsg)
+			log.Fatal(err)
+		}
+	} else if command == "pdf" {
+		flags := flag.NewFlagSet("pdf", flag.ExitOnError)
+		flags.Usage = func() { fmt.Println(helpPDFMsg) }
+		outDir := flags.String("out-dir", "data", "directory of the downloaded images")
+		width := flags.String("width", "w1500", "width variant of the pages")
+		hns := flags.String("hn", "", "comma separated HNs or HN ranges")
+		books := flags.String("books", "", "JSON output of crawl details")
+		if err := flags.Parse(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		list, err := henle.ParseHNs(*hns)
+		if err != nil {
+			fmt.Println(helpPDFMsg)
+			log.Fatal(err)
+		}
+		var snapshot henle.Snapshot
+		if *books != "" {
+			if snapshot, err = henle.LoadSnapshotFile(*books); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := henle.WriteBookPDFs(*outDir, *width, list, snapshot, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	} else if command == "iiif" {
+		flags := flag.NewFlagSet("iiif", flag.ExitOnError)
+		flags.Usage = func() { fmt.Println(helpIIIFMsg) }
+		outDir := flags.String("out-dir", "data", "directory of the downloaded images")
+		baseURL := flags.String("base-url", "http://localhost:8080", "URL the server is reached at")
+		hns := flags.String("hn", "", "comma separated HNs or HN ranges")
+		books := flags.String("books", "", "JSON output of crawl details")
+		if err := flags.Parse(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		list, err := henle.ParseHNs(*hns)
+		if err != nil {
+			fmt.Println(helpIIIFMsg)
+			log.Fatal(err)
+		}
+		var snapshot henle.Snapshot
+		if *books != "" {
+			if snapshot, err = henle.LoadSnapshotFile(*books); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := henle.WriteIIIFManifests(*outDir, *baseURL, list, snapshot, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	} else if command == "serve" {
+		flags := flag.NewFlagSet("serve", flag.ExitOnError)
+		flags.Usage = func() { fmt.Println(helpServeMsg) }
+		addr := flags.String("addr", ":8080", "address to listen on")
+		dataDir := flags.String("data-dir", "data", "directory of the downloaded images")
+		if err := flags.Parse(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		server.SetDataDir(*dataDir)
+		r := server.SetupRouter()
+		if err := r.Run(*addr); err != nil {
+			log.Fatal(err)
+		}
+	} else if command == "duplicates" {
+		flags := flag.NewFlagSet("duplicates", flag.ExitOnError)
+		flags.Usage = func() { fmt.Println(helpDuplicatesMsg) }
+		outDir := flags.String("out-dir", "data", "directory of the downloaded images")
+		coversDir := flags.String("covers-dir", "", "directory of the downloaded covers")
+		hns := flags.String("hn", "", "comma separated HNs or HN ranges")
+		distance := flags.Int("distance", henle.DefaultDuplicateDistance, "largest Hamming distance of near-duplicates")
+		report := flags.String("report", "", "file the clusters are written to")
+		if err := flags.Parse(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		list, err := henle.ParseHNs(*hns)
+		if err != nil {
+			fmt.Println(helpDuplicatesMsg)
+			log.Fatal(err)
+		}
+		if *report == "" {
+			*report = filepath.Join(*outDir, "henle-duplicates.json")
+		}
+		if err := henle.WriteDuplicateReport(*outDir, *coversDir, list, *distance, *report, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	} else if command == "notation" {
+		flags := flag.NewFlagSet("notation", flag.ExitOnError)
+		flags.Usage = func() { fmt.Println(helpNotationMsg) }
+		outDir := flags.String("out-dir", "data", "directory of the downloaded images")
+		width := flags.String("width", "w1500", "width variant of the pages")
+		hns := flags.String("hn", "", "comma separated HNs or HN ranges")
+		out := flags.String("out", "", "file the JSON lines are written to")
+		if err := flags.Parse(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		list, err := henle.ParseHNs(*hns)
+		if err != nil {
+			fmt.Println(helpNotationMsg)
+			log.Fatal(err)
+		}
+		if *out == "" {
+			*out = filepath.Join(*outDir, "henle-notation.jsonl")
+		}
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		if err := henle.WriteNotationFeatures(*outDir, *width, list, f, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	} else if command == "download" {
+		if len(os.Args) < 3 {
 			fmt.Println(helpDownloadMsg)
-			log.Fatal("Invalid argument 1")
-		}
-		var outDir string
-		var from string
-		if os.Args[2] == "--out-dir" {
-			outDir = os.Args[3]
-		} else if os.Args[2] == "--from" {
-			from = os.Args[3]
-		} else {
+			log.Fatal("Missing destination")
+		}
+		destination := os.Args[2]
+		flags := flag.NewFlagSet("download "+destination, flag.ExitOnError)
+		flags.Usage = func() { fmt.Println(helpDownloadMsg) }
+		outDir := flags.String("out-dir", "", "output directory")
+		from := flags.String("from", "", "input file")
+		gatewayURLs := flags.String("gateways", strings.Join(ipfs.DefaultGateways, ","), "comma separated IPFS gateways")
+		api := flags.String("ipfs-api", "", "RPC API URL of a local IPFS node")
+		timeout := flags.Duration("timeout", ipfs.DefaultTimeout, "how long a gateway gets to answer")
+		politeness := politenessFlags(flags)
+		if err := flags.Parse(os.Args[3:]); err != nil {
+			log.Fatal(err)
+		}
+		if destination != "musescore" {
 			fmt.Println(helpDownloadMsg)
-			log.Fatal("Invalid argument 2")
+			log.Fatal("Invalid destination ", destination)
 		}
-		if os.Args[4] == "--out-dir" {
-			outDir = os.Args[5]
-		} else if os.Args[4] == "--from" {
-			from = os.Args[5]
-		} else {
+		if *outDir == "" || *from == "" {
 			fmt.Println(helpDownloadMsg)
-			log.Fatal("Invalid argument 4")
-		}
-		ipfs.DownloadMuseScore(
-			1,
-			outDir,
-			from,
-			8, // max collectors running
-		)
+			log.Fatal("Missing --out-dir or --from")
+		}
+		gateways := ipfs.Gateways{API: *api, Timeout: *timeout}
+		for _, u := range strings.Split(*gatewayURLs, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				gateways.URLs = append(gateways.URLs, u)
+			}
+		}
+		ipfs.DownloadMuseScore(1, *outDir, *from, gateways, *politeness)
 	} else {
 		fmt.Println(helpMsg)
 	}
@@ -381,6 +1020,7 @@ func main() {
 	//	1,
 	//	filepath.Join("D:\\", "data/MDC/musescore"),
 	//	filepath.Join("D:\\code\\github.com\\bluemonarch21\\mdc", "assets/mscz-files.csv"),
-	//	8, // max collectors running
+	//	ipfs.Gateways{},
+	//	crawl.Politeness{Parallelism: 8}, // max collectors running
 	//)
 }
@@ -0,0 +1,200 @@
+// Package pdf writes PDF documents of JPEG images, one image per page.
+// The JPEG data is embedded as is with the DCTDecode filter, without decoding it.
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Info is the document information of a PDF. Empty fields are left out.
+type Info struct {
+	Title    string
+	Author   string
+	Subject  string
+	Keywords string
+	Creator  string
+}
+
+// DefaultDPI is the resolution the page size of an image is computed with when Writer.DPI is 0.
+const DefaultDPI = 150
+
+// Writer writes a PDF page by page. Create it with NewWriter, add the pages with AddJPEG and call Close.
+type Writer struct {
+	// Info is written by Close.
+	Info Info
+	// DPI is the resolution of the images, setting the page sizes in points.
+	DPI float64
+
+	w       *bufio.Writer
+	offset  int64
+	offsets []int64 // byte offset of every object, object n at index n-1
+	pages   []int   // object numbers of the pages
+	err     error
+}
+
+// Object numbers reserved for the objects written by Close.
+const (
+	catalogObject = 1
+	pagesObject   = 2
+	infoObject    = 3
+)
+
+// NewWriter returns a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	pw := &Writer{w: bufio.NewWriter(w), offsets: make([]int64, infoObject)}
+	// the binary comment marks the file as binary for transfer programs
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+	return pw
+}
+
+// printf writes to the PDF, keeping track of the offset and the first error.
+func (w *Writer) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.offset += int64(n)
+	w.err = err
+}
+
+func (w *Writer) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.Write(data)
+	w.offset += int64(n)
+	w.err = err
+}
+
+// newObject allocates the number of an object written later with beginObject.
+func (w *Writer) newObject() int {
+	w.offsets = append(w.offsets, 0)
+	return len(w.offsets)
+}
+
+func (w *Writer) beginObject(n int) {
+	w.offsets[n-1] = w.offset
+	w.printf("%d 0 obj\n", n)
+}
+
+func (w *Writer) endObject() {
+	w.printf("endobj\n")
+}
+
+// AddJPEG adds a page showing the JPEG image data, sized by its dimensions at w.DPI.
+func (w *Writer) AddJPEG(data []byte) error {
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	colorSpace, decode := "DeviceRGB", ""
+	switch config.ColorModel {
+	case color.GrayModel:
+		colorSpace = "DeviceGray"
+	case color.CMYKModel:
+		// Adobe CMYK JPEGs are stored inverted
+		colorSpace, decode = "DeviceCMYK", " /Decode [1 0 1 0 1 0 1 0]"
+	}
+	dpi := w.DPI
+	if dpi <= 0 {
+		dpi = DefaultDPI
+	}
+	width := float64(config.Width) * 72 / dpi
+	height := float64(config.Height) * 72 / dpi
+
+	img := w.newObject()
+	w.beginObject(img)
+	w.printf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent 8%s /Filter /DCTDecode /Length %d >>\nstream\n",
+		config.Width, config.Height, colorSpace, decode, len(data))
+	w.write(data)
+	w.printf("\nendstream\n")
+	w.endObject()
+
+	content := fmt.Sprintf("q %.2f 0 0 %.2f 0 0 cm /Im0 Do Q\n", width, height)
+	contents := w.newObject()
+	w.beginObject(contents)
+	w.printf("<< /Length %d >>\nstream\n%sendstream\n", len(content), content)
+	w.endObject()
+
+	page := w.newObject()
+	w.beginObject(page)
+	w.printf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>\n",
+		pagesObject, width, height, img, contents)
+	w.endObject()
+	w.pages = append(w.pages, page)
+	return w.err
+}
+
+// Close writes the page tree, the document information and the cross-reference table, and flushes the PDF.
+// It does not close the underlying writer.
+func (w *Writer) Close() error {
+	w.beginObject(pagesObject)
+	kids := make([]string, len(w.pages))
+	for i, page := range w.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", page)
+	}
+	w.printf("<< /Type /Pages /Kids [%s] /Count %d >>\n", strings.Join(kids, " "), len(w.pages))
+	w.endObject()
+
+	w.beginObject(catalogObject)
+	w.printf("<< /Type /Catalog /Pages %d 0 R >>\n", pagesObject)
+	w.endObject()
+
+	w.beginObject(infoObject)
+	w.printf("<<")
+	for _, field := range []struct{ key, value string }{
+		{"Title", w.Info.Title},
+		{"Author", w.Info.Author},
+		{"Subject", w.Info.Subject},
+		{"Keywords", w.Info.Keywords},
+		{"Creator", w.Info.Creator},
+	} {
+		if field.value != "" {
+			w.printf(" /%s %s", field.key, textString(field.value))
+		}
+	}
+	w.printf(" /CreationDate (D:%s) >>\n", time.Now().UTC().Format("20060102150405Z"))
+	w.endObject()
+
+	xref := w.offset
+	w.printf("xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		w.printf("%010d 00000 n \n", offset)
+	}
+	w.printf("trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(w.offsets)+1, catalogObject, infoObject, xref)
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// textString encodes s as a PDF text string: a literal string if it is printable ASCII,
+// UTF-16BE with a byte order mark in hex otherwise.
+func textString(s string) string {
+	ascii := true
+	for _, r := range s {
+		if r < 0x20 || r > 0x7e {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return "(" + strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(s) + ")"
+	}
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	b.WriteString(">")
+	return b.String()
+}
@@ -0,0 +1,167 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testJPEG returns a JPEG of the given size, in gray or in color.
+func testJPEG(t *testing.T, width, height int, gray bool) []byte {
+	t.Helper()
+	var img image.Image
+	if gray {
+		g := image.NewGray(image.Rect(0, 0, width, height))
+		for i := range g.Pix {
+			g.Pix[i] = uint8(i)
+		}
+		img = g
+	} else {
+		rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+		for x := 0; x < width; x++ {
+			for y := 0; y < height; y++ {
+				rgba.Set(x, y, color.RGBA{uint8(x), uint8(y), 0x80, 0xff})
+			}
+		}
+		img = rgba
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var startxrefPattern = regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`)
+
+// object returns the text of object n of doc, checking that the cross-reference table points at it.
+func object(t *testing.T, doc []byte, n int) string {
+	t.Helper()
+	m := startxrefPattern.FindSubmatch(doc)
+	if m == nil {
+		t.Fatal("no startxref at the end of the PDF")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	table := doc[xref:]
+	header := fmt.Sprintf("xref\n0 %d\n", bytes.Count(table[:bytes.Index(table, []byte("trailer"))], []byte(" n \n"))+1)
+	if !bytes.HasPrefix(table, []byte(header)) {
+		t.Fatalf("startxref %d points at %q, want %q", xref, table[:20], header)
+	}
+	entry := table[len(header)+20*n : len(header)+20*(n+1)]
+	offset, err := strconv.Atoi(string(entry[:10]))
+	if err != nil || string(entry[10:]) != " 00000 n \n" {
+		t.Fatalf("xref entry %d is %q, want 20 bytes of an object in use", n, entry)
+	}
+	obj := doc[offset:]
+	if want := fmt.Sprintf("%d 0 obj\n", n); !bytes.HasPrefix(obj, []byte(want)) {
+		t.Fatalf("xref entry %d points at %q, want %q", n, obj[:20], want)
+	}
+	return string(obj[:bytes.Index(obj, []byte("endobj"))])
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Info = Info{Title: "Iberia · Fourth Book", Author: "Albéniz, Isaac", Keywords: "HN 0650", Creator: "matchmaker"}
+	pages := []struct {
+		width, height int
+		gray          bool
+	}{
+		{150, 300, true},
+		{300, 150, false},
+		{75, 75, true},
+	}
+	for _, p := range pages {
+		if err := w.AddJPEG(testJPEG(t, p.width, p.height, p.gray)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	doc := buf.Bytes()
+	if !bytes.HasPrefix(doc, []byte("%PDF-1.4\n")) {
+		t.Errorf("got header %q", doc[:9])
+	}
+
+	// 3 reserved objects, then an image, a content stream and a page per page
+	objects := infoObject + 3*len(pages)
+	for n := 1; n <= objects; n++ {
+		object(t, doc, n)
+	}
+	if !strings.Contains(string(doc), fmt.Sprintf("trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>", objects+1)) {
+		t.Error("trailer does not name the catalog and info objects")
+	}
+
+	if catalog := object(t, doc, catalogObject); !strings.Contains(catalog, "/Type /Catalog /Pages 2 0 R") {
+		t.Errorf("catalog: %s", catalog)
+	}
+	tree := object(t, doc, pagesObject)
+	if !strings.Contains(tree, fmt.Sprintf("/Count %d", len(pages))) {
+		t.Errorf("page tree: %s, want /Count %d", tree, len(pages))
+	}
+	for i, p := range pages {
+		page := object(t, doc, infoObject+3*i+3)
+		// 150 DPI by default
+		box := fmt.Sprintf("/MediaBox [0 0 %.2f %.2f]", float64(p.width)*72/150, float64(p.height)*72/150)
+		if !strings.Contains(page, "/Type /Page /Parent 2 0 R") || !strings.Contains(page, box) {
+			t.Errorf("page %d: %s, want %s", i+1, page, box)
+		}
+		if !strings.Contains(tree, fmt.Sprintf("%d 0 R", infoObject+3*i+3)) {
+			t.Errorf("page %d is not a kid of the page tree %s", i+1, tree)
+		}
+		img := object(t, doc, infoObject+3*i+1)
+		colorSpace := "/ColorSpace /DeviceRGB"
+		if p.gray {
+			colorSpace = "/ColorSpace /DeviceGray"
+		}
+		if !strings.Contains(img, fmt.Sprintf("/Width %d /Height %d %s", p.width, p.height, colorSpace)) {
+			t.Errorf("image %d: %.120s", i+1, img)
+		}
+	}
+
+	info := object(t, doc, infoObject)
+	for _, want := range []string{
+		"/Title " + textString("Iberia · Fourth Book"),
+		"/Author " + textString("Albéniz, Isaac"),
+		"/Keywords (HN 0650)",
+		"/Creator (matchmaker)",
+		"/CreationDate (D:",
+	} {
+		if !strings.Contains(info, want) {
+			t.Errorf("info %s does not hold %s", info, want)
+		}
+	}
+	if strings.Contains(info, "/Subject") {
+		t.Errorf("info %s holds the empty Subject", info)
+	}
+}
+
+func TestAddJPEGInvalid(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{})
+	if err := w.AddJPEG([]byte("not a JPEG")); err == nil {
+		t.Error("got no error adding data that is not a JPEG")
+	}
+}
+
+func TestTextString(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"HN 0650", "(HN 0650)"},
+		{`a (b) \c`, `(a \(b\) \\c)`},
+		{"Bartók", "<FEFF004200610072007400F3006B>"},
+	}
+	for _, test := range tests {
+		if got := textString(test.s); got != test.want {
+			t.Errorf("textString(%q) = %s, want %s", test.s, got, test.want)
+		}
+	}
+}
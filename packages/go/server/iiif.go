@@ -0,0 +1,116 @@
+package server
+
+import (
+	"github.com/bluemonarch21/matchmaker/henle"
+	"github.com/bluemonarch21/matchmaker/iiif"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+var dataDir string
+
+// SetDataDir sets the output directory of the Henle image crawl, whose IIIF manifests and images are served.
+func SetDataDir(dir string) {
+	dataDir = dir
+}
+
+// setupIIIFRoutes serves the IIIF layout of henle.IIIFManifestURL and henle.IIIFImageURL from dataDir:
+// the manifests written by henle.WriteIIIFManifests, and the pages as IIIF Image API level 0 services
+// whose sizes are the downloaded width variants. The ids of both are under the URL of the request,
+// whatever base URL the manifests were written with.
+func setupIIIFRoutes(r *gin.Engine) {
+	// IIIF viewers are usually served from another origin
+	cors := func(c *gin.Context) {
+		c.Header("Access-Control-Allow-Origin", "*")
+	}
+	iiifRoutes := r.Group("/iiif/henle", cors)
+
+	iiifRoutes.GET("/manifests/:hn", func(c *gin.Context) {
+		hn, err := strconv.Atoi(c.Param("hn"))
+		if err != nil || dataDir == "" {
+			c.String(http.StatusNotFound, "Manifest not found")
+			return
+		}
+		data, err := os.ReadFile(henle.IIIFManifestFile(dataDir, hn))
+		if err != nil {
+			c.String(http.StatusNotFound, "Manifest not found")
+			return
+		}
+		data, err = henle.RebaseIIIFManifest(data, hn, requestBaseURL(c))
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Data(http.StatusOK, `application/ld+json;profile="`+iiif.PresentationContext+`"`, data)
+	})
+
+	iiifRoutes.GET("/images/:id/*request", func(c *gin.Context) {
+		hn, page, err := henle.ParseIIIFImageID(c.Param("id"))
+		if err != nil || dataDir == "" {
+			c.String(http.StatusNotFound, "Image not found")
+			return
+		}
+		manifest, err := henle.ReadImageManifest(filepath.Join(henle.BookDir(dataDir, hn), "manifest.json"))
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		manifest.HN = hn
+		variants := manifest.PageVariants(page)
+		if len(variants) == 0 {
+			c.String(http.StatusNotFound, "Image not found")
+			return
+		}
+
+		request := c.Param("request")
+		if request == "/" || request == "/info.json" {
+			info, err := manifest.IIIFImageInfo(requestBaseURL(c), page)
+			if err != nil {
+				c.String(http.StatusNotFound, err.Error())
+				return
+			}
+			if request == "/" {
+				c.Redirect(http.StatusSeeOther, info.ID+"/info.json")
+				return
+			}
+			c.Header("Content-Type", `application/ld+json;profile="`+iiif.ImageContext+`"`)
+			c.JSON(http.StatusOK, info)
+			return
+		}
+
+		imageRequest, err := iiif.ParseImageRequest(request)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		sizes := make([]iiif.Size, len(variants))
+		for i, v := range variants {
+			sizes[i] = iiif.Size{Width: v.Width, Height: v.Height}
+		}
+		size, err := imageRequest.Match(sizes)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		for _, v := range variants {
+			if v.Width == size.Width && v.Height == size.Height {
+				c.File(filepath.Join(henle.BookDir(dataDir, hn), filepath.FromSlash(v.File)))
+				return
+			}
+		}
+	})
+}
+
+// requestBaseURL returns the scheme and host the request was sent to, e.g. "http://localhost:8080".
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	} else if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
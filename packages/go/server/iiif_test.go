@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/bluemonarch21/matchmaker/henle"
+	"github.com/bluemonarch21/matchmaker/iiif"
+	"github.com/gin-gonic/gin"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeIIIFBook writes page 1 of the book 650 in the variants w500 and w1000 under a temporary data directory,
+// and its IIIF manifest with the ids under writtenBase, and returns the directory.
+func writeIIIFBook(t *testing.T, writtenBase string) string {
+	t.Helper()
+	dir := t.TempDir()
+	bookDir := henle.BookDir(dir, 650)
+	manifest := henle.ImageManifest{HN: 650}
+	for _, width := range []int{500, 1000} {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, width*2)), nil); err != nil {
+			t.Fatal(err)
+		}
+		variant := fmt.Sprintf("w%d", width)
+		file := variant + "/0001.jpg"
+		if err := os.MkdirAll(filepath.Join(bookDir, variant), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(bookDir, filepath.FromSlash(file)), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		manifest.Set(henle.PageImage{Page: 1, Variant: variant, File: file, Width: width, Height: width * 2})
+	}
+	if err := manifest.WriteFile(filepath.Join(bookDir, "manifest.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := henle.WriteIIIFManifests(dir, writtenBase, []int{650}, nil, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestIIIFRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	SetDataDir(writeIIIFBook(t, "https://written.example"))
+	defer SetDataDir("")
+	r := gin.New()
+	setupIIIFRoutes(r)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	get := func(path string) *http.Response {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := get("/iiif/henle/manifests/0650")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("manifest: got status %d, headers %v", resp.StatusCode, resp.Header)
+	}
+	var manifest iiif.Manifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	if want := server.URL + "/iiif/henle/manifests/0650"; manifest.ID != want {
+		t.Errorf("got manifest id %s, want %s", manifest.ID, want)
+	}
+	if len(manifest.Items) != 1 {
+		t.Fatalf("got %d canvases, want 1", len(manifest.Items))
+	}
+	body := manifest.Items[0].Items[0].Items[0].Body
+	if body.Width != 1000 || body.Height != 2000 {
+		t.Errorf("got canvas image of %dx%d, want the largest variant", body.Width, body.Height)
+	}
+	service := body.Service[0].ID
+
+	// the info.json of the service of the manifest, at the URL of the manifest
+	resp = get(service[len(server.URL):] + "/info.json")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("info.json: got status %d", resp.StatusCode)
+	}
+	var info iiif.ImageInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != service {
+		t.Errorf("got info.json id %s, want the service id of the manifest %s", info.ID, service)
+	}
+	if info.Width != 1000 || len(info.Sizes) != 2 || info.Sizes[1] != (iiif.Size{Width: 500, Height: 1000}) {
+		t.Errorf("got info %+v", info)
+	}
+
+	for path, width := range map[string]int{
+		"/iiif/henle/images/0650-0001/full/max/0/default.jpg":   1000,
+		"/iiif/henle/images/0650-0001/full/500,/0/default.jpg":  500,
+		"/iiif/henle/images/0650-0001/full/,2000/0/default.jpg": 1000,
+	} {
+		resp := get(path)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: got status %d", path, resp.StatusCode)
+			continue
+		}
+		config, err := jpeg.DecodeConfig(resp.Body)
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+		} else if config.Width != width {
+			t.Errorf("%s: got an image %d wide, want %d", path, config.Width, width)
+		}
+	}
+
+	for path, status := range map[string]int{
+		"/iiif/henle/manifests/0651":                            http.StatusNotFound,
+		"/iiif/henle/images/0650-0002/info.json":                http.StatusNotFound,
+		"/iiif/henle/images/page-1/info.json":                   http.StatusNotFound,
+		"/iiif/henle/images/0650-0001/full/750,/0/default.jpg":  http.StatusBadRequest,
+		"/iiif/henle/images/0650-0001/square/max/0/default.jpg": http.StatusBadRequest,
+		"/iiif/henle/images/0650-0001/full/max/0":               http.StatusBadRequest,
+	} {
+		if resp := get(path); resp.StatusCode != status {
+			t.Errorf("%s: got status %d, want %d", path, resp.StatusCode, status)
+		}
+	}
+}
@@ -57,7 +57,15 @@ func SetupRouter() *gin.Engine {
 	// gin.DisableConsoleColor()
 	r := gin.Default()
 
-	r.Use(gin.WrapH(websocket.Handler(EchoServer)))
+	// Only upgrade requests are handled by the websocket, it hijacks the connection
+	r.Use(func(c *gin.Context) {
+		if strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+			websocket.Handler(EchoServer).ServeHTTP(c.Writer, c.Request)
+			c.Abort()
+		}
+	})
+
+	setupIIIFRoutes(r)
 
 	// Ping test
 	r.GET("/ping", func(c *gin.Context) {
@@ -196,9 +204,9 @@ func SetupRouter() *gin.Engine {
 				log.Fatal("Failure at decoding document")
 			}
 			//log.Println("got record", record)
+			wgDone.Add(1)
+			//log.Println("done++")
 			go func() {
-				wgDone.Add(1)
-				//log.Println("done++")
 				defer wgDone.Done()
 				//defer func() {
 				//	log.Println("finished one")
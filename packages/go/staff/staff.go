@@ -0,0 +1,320 @@
+// Package staff finds the staff systems of scanned or engraved music pages, to estimate the density
+// of their notation from the images alone.
+//
+// Staff lines are the rows of dark pixels spanning most of the width of the music, five evenly spaced
+// lines make a staff, and consecutive staves joined at their left end by a barline or bracket make a system.
+package staff
+
+import (
+	"image"
+)
+
+// darkLevel is the gray level, out of 0xffff, below which a pixel counts as ink.
+const darkLevel = 0x8000
+
+// lineCoverage is the part of the width of the music a row must be dark across to be a staff line.
+const lineCoverage = 0.5
+
+// System is a staff system of a page, with coordinates in pixels from the top left of the page.
+type System struct {
+	Top          int // y of the top line of the first staff
+	Bottom       int // y of the bottom line of the last staff
+	Left         int // x where the staff lines start
+	Right        int // x where the staff lines end
+	Staves       int
+	StaffSpacing float64 // average distance between the lines of a staff
+	InkDensity   float64 // part of the pixels of the system that are dark, staff lines left out
+}
+
+// Analysis holds the staff systems of a page.
+type Analysis struct {
+	Width   int
+	Height  int
+	Systems []System
+	Staves  int    // most common number of staves of the systems, 0 if none were found
+	Layout  string // see Layout
+}
+
+// Layout tells the kind of music of a page from its number of staves per system:
+// "solo" for one staff, "grand" for two, as for piano, "score" for more, and "" for none.
+func Layout(staves int) string {
+	switch {
+	case staves <= 0:
+		return ""
+	case staves == 1:
+		return "solo"
+	case staves == 2:
+		return "grand"
+	default:
+		return "score"
+	}
+}
+
+// bitmap holds which pixels of an image are dark.
+type bitmap struct {
+	width, height int
+	dark          []bool
+}
+
+func newBitmap(img image.Image) bitmap {
+	b := img.Bounds()
+	bm := bitmap{b.Dx(), b.Dy(), make([]bool, b.Dx()*b.Dy())}
+	for y := 0; y < bm.height; y++ {
+		for x := 0; x < bm.width; x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			bm.dark[y*bm.width+x] = (19595*r+38470*g+7471*bl+1<<15)>>16 < darkLevel
+		}
+	}
+	return bm
+}
+
+func (bm bitmap) at(x, y int) bool {
+	return x >= 0 && y >= 0 && x < bm.width && y < bm.height && bm.dark[y*bm.width+x]
+}
+
+// longestRun returns the start and end of the longest run of dark pixels of row y.
+func (bm bitmap) longestRun(y int) (int, int) {
+	start, end, runStart := 0, 0, -1
+	for x := 0; x <= bm.width; x++ {
+		if x < bm.width && bm.at(x, y) {
+			if runStart < 0 {
+				runStart = x
+			}
+		} else if runStart >= 0 {
+			if x-runStart > end-start {
+				start, end = runStart, x
+			}
+			runStart = -1
+		}
+	}
+	return start, end
+}
+
+// line is a staff line, rows Top to Bottom inclusive, dark from Left to Right.
+type line struct {
+	top, bottom int
+	left, right int
+}
+
+func (l line) center() float64 {
+	return float64(l.top+l.bottom) / 2
+}
+
+// staff is five staff lines.
+type staff struct {
+	lines [5]line
+}
+
+func (s staff) spacing() float64 {
+	return (s.lines[4].center() - s.lines[0].center()) / 4
+}
+
+func (s staff) left() int {
+	left := s.lines[0].left
+	for _, l := range s.lines[1:] {
+		if l.left < left {
+			left = l.left
+		}
+	}
+	return left
+}
+
+func (s staff) right() int {
+	right := s.lines[0].right
+	for _, l := range s.lines[1:] {
+		if l.right > right {
+			right = l.right
+		}
+	}
+	return right
+}
+
+// findLines returns the staff lines of bm, from top to bottom.
+func findLines(bm bitmap) []line {
+	// rows whose longest dark run spans most of the widest run of the page
+	starts := make([]int, bm.height)
+	ends := make([]int, bm.height)
+	widest := 0
+	for y := 0; y < bm.height; y++ {
+		starts[y], ends[y] = bm.longestRun(y)
+		if ends[y]-starts[y] > widest {
+			widest = ends[y] - starts[y]
+		}
+	}
+	if widest == 0 {
+		return nil
+	}
+	var lines []line
+	for y := 0; y < bm.height; y++ {
+		if float64(ends[y]-starts[y]) < lineCoverage*float64(widest) {
+			continue
+		}
+		if n := len(lines); n > 0 && lines[n-1].bottom == y-1 {
+			l := &lines[n-1]
+			l.bottom = y
+			if starts[y] < l.left {
+				l.left = starts[y]
+			}
+			if ends[y] > l.right {
+				l.right = ends[y]
+			}
+			continue
+		}
+		lines = append(lines, line{y, y, starts[y], ends[y]})
+	}
+	return lines
+}
+
+// findStaves groups lines into staves of five evenly spaced lines, from top to bottom.
+func findStaves(lines []line) []staff {
+	var staves []staff
+	for i := 0; i+5 <= len(lines); {
+		var s staff
+		copy(s.lines[:], lines[i:i+5])
+		if evenlySpaced(s) {
+			staves = append(staves, s)
+			i += 5
+		} else {
+			i++
+		}
+	}
+	return staves
+}
+
+// evenlySpaced tells whether the gaps between the lines of s are alike and wider than the lines.
+func evenlySpaced(s staff) bool {
+	minGap, maxGap := 0.0, 0.0
+	for i := 1; i < 5; i++ {
+		gap := s.lines[i].center() - s.lines[i-1].center()
+		thickness := float64(s.lines[i].bottom - s.lines[i].top + 1)
+		if gap < 2*thickness+1 {
+			return false
+		}
+		if i == 1 || gap < minGap {
+			minGap = gap
+		}
+		if gap > maxGap {
+			maxGap = gap
+		}
+	}
+	return maxGap <= 1.3*minGap
+}
+
+// joined tells whether a column at the left end of staves a and b, a above b, is dark from a to b,
+// as the barline or bracket starting a system is.
+func joined(bm bitmap, a staff, b staff) bool {
+	top := a.lines[4].bottom + 1
+	bottom := b.lines[0].top - 1
+	if bottom < top {
+		return true
+	}
+	left := a.left()
+	if b.left() < left {
+		left = b.left()
+	}
+	reach := int(a.spacing())
+	for x := left - 2; x <= left+reach; x++ {
+		dark := 0
+		for y := top; y <= bottom; y++ {
+			if bm.at(x, y) || bm.at(x-1, y) || bm.at(x+1, y) {
+				dark++
+			}
+		}
+		// JPEG artifacts may break a barline
+		if float64(dark) >= 0.95*float64(bottom-top+1) {
+			return true
+		}
+	}
+	return false
+}
+
+// Detect finds the staff systems of a page of music.
+func Detect(img image.Image) Analysis {
+	bm := newBitmap(img)
+	page := Analysis{Width: bm.width, Height: bm.height}
+	lines := findLines(bm)
+	staves := findStaves(lines)
+
+	// staves joined to the one above belong to its system
+	var groups [][]staff
+	for i, s := range staves {
+		if i > 0 && joined(bm, staves[i-1], s) {
+			groups[len(groups)-1] = append(groups[len(groups)-1], s)
+		} else {
+			groups = append(groups, []staff{s})
+		}
+	}
+
+	staffRows := make([]bool, bm.height)
+	for _, l := range lines {
+		for y := l.top; y <= l.bottom; y++ {
+			staffRows[y] = true
+		}
+	}
+	counts := make(map[int]int)
+	for i, group := range groups {
+		first, last := group[0], group[len(group)-1]
+		system := System{
+			Top:    first.lines[0].top,
+			Bottom: last.lines[4].bottom,
+			Left:   first.left(),
+			Right:  first.right(),
+			Staves: len(group),
+		}
+		for _, s := range group {
+			system.StaffSpacing += s.spacing()
+			if s.left() < system.Left {
+				system.Left = s.left()
+			}
+			if s.right() > system.Right {
+				system.Right = s.right()
+			}
+		}
+		system.StaffSpacing /= float64(len(group))
+
+		// the notes above and below the staves, up to halfway to the neighbouring systems
+		top := system.Top - int(2*system.StaffSpacing)
+		if i > 0 {
+			if above := groups[i-1][len(groups[i-1])-1].lines[4].bottom; top < (above+system.Top)/2 {
+				top = (above + system.Top) / 2
+			}
+		}
+		bottom := system.Bottom + int(2*system.StaffSpacing)
+		if i+1 < len(groups) {
+			if below := groups[i+1][0].lines[0].top; bottom > (system.Bottom+below)/2 {
+				bottom = (system.Bottom + below) / 2
+			}
+		}
+		system.InkDensity = inkDensity(bm, staffRows, image.Rect(system.Left, top, system.Right, bottom+1))
+		page.Systems = append(page.Systems, system)
+		counts[system.Staves]++
+	}
+	for staves, n := range counts {
+		if n > counts[page.Staves] || (n == counts[page.Staves] && staves > page.Staves) {
+			page.Staves = staves
+		}
+	}
+	page.Layout = Layout(page.Staves)
+	return page
+}
+
+// inkDensity returns the part of the pixels of r that are dark, leaving out the rows of staff lines.
+func inkDensity(bm bitmap, staffRows []bool, r image.Rectangle) float64 {
+	r = r.Intersect(image.Rect(0, 0, bm.width, bm.height))
+	dark, total := 0, 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		if staffRows[y] {
+			continue
+		}
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if bm.dark[y*bm.width+x] {
+				dark++
+			}
+			total++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(dark) / float64(total)
+}
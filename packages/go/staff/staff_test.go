@@ -0,0 +1,204 @@
+package staff
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// page is a synthetic page of music drawn in black on white.
+type page struct {
+	*image.Gray
+}
+
+func newPage(width, height int) page {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	return page{img}
+}
+
+func (p page) fill(x0, y0, x1, y1 int) {
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			p.SetGray(x, y, color.Gray{0})
+		}
+	}
+}
+
+// staff draws five lines 2 pixels thick from x 50 to 550, the first at top, each spacing below the one before.
+// It returns the y of the bottom of the last line.
+func (p page) staff(top int, spacing int) int {
+	for i := 0; i < 5; i++ {
+		p.fill(50, top+i*spacing, 550, top+i*spacing+2)
+	}
+	return top + 4*spacing + 1
+}
+
+// barline joins the staves from the top of the line at top to the bottom of the line ending at bottom.
+func (p page) barline(top int, bottom int) {
+	p.fill(50, top, 52, bottom+1)
+}
+
+func TestDetect(t *testing.T) {
+	const spacing = 10
+	tests := []struct {
+		name    string
+		draw    func(p page)
+		systems []System // only Top, Bottom, Staves and StaffSpacing are compared
+		staves  int
+		layout  string
+	}{
+		{
+			name: "blank",
+			draw: func(p page) {},
+		},
+		{
+			name: "solo",
+			draw: func(p page) {
+				p.staff(100, spacing)
+			},
+			systems: []System{{Top: 100, Bottom: 141, Staves: 1, StaffSpacing: spacing}},
+			staves:  1,
+			layout:  "solo",
+		},
+		{
+			name: "grand",
+			draw: func(p page) {
+				p.staff(100, spacing)
+				bottom := p.staff(180, spacing)
+				p.barline(100, bottom)
+			},
+			systems: []System{{Top: 100, Bottom: 221, Staves: 2, StaffSpacing: spacing}},
+			staves:  2,
+			layout:  "grand",
+		},
+		{
+			name: "score",
+			draw: func(p page) {
+				p.staff(100, spacing)
+				p.staff(180, spacing)
+				p.staff(260, spacing)
+				bottom := p.staff(340, spacing)
+				p.barline(100, bottom)
+			},
+			systems: []System{{Top: 100, Bottom: 381, Staves: 4, StaffSpacing: spacing}},
+			staves:  4,
+			layout:  "score",
+		},
+		{
+			name: "unjoined staves",
+			draw: func(p page) {
+				p.staff(100, spacing)
+				p.staff(300, spacing)
+			},
+			systems: []System{
+				{Top: 100, Bottom: 141, Staves: 1, StaffSpacing: spacing},
+				{Top: 300, Bottom: 341, Staves: 1, StaffSpacing: spacing},
+			},
+			staves: 1,
+			layout: "solo",
+		},
+		{
+			name: "grand systems",
+			draw: func(p page) {
+				for _, top := range []int{100, 400} {
+					p.staff(top, spacing)
+					bottom := p.staff(top+80, spacing)
+					p.barline(top, bottom)
+				}
+			},
+			systems: []System{
+				{Top: 100, Bottom: 221, Staves: 2, StaffSpacing: spacing},
+				{Top: 400, Bottom: 521, Staves: 2, StaffSpacing: spacing},
+			},
+			staves: 2,
+			layout: "grand",
+		},
+		{
+			name: "barline with a gap",
+			draw: func(p page) {
+				p.staff(100, spacing)
+				bottom := p.staff(180, spacing)
+				p.barline(100, 155)
+				p.barline(165, bottom)
+			},
+			systems: []System{
+				{Top: 100, Bottom: 141, Staves: 1, StaffSpacing: spacing},
+				{Top: 180, Bottom: 221, Staves: 1, StaffSpacing: spacing},
+			},
+			staves: 1,
+			layout: "solo",
+		},
+		{
+			name: "uneven lines",
+			draw: func(p page) {
+				// the last gap is more than 1.3 times the others
+				for _, y := range []int{100, 110, 120, 130, 145} {
+					p.fill(50, y, 550, y+2)
+				}
+			},
+		},
+		{
+			name: "short lines",
+			draw: func(p page) {
+				p.staff(100, spacing)
+				// less than half as wide as the staff above
+				for i := 0; i < 5; i++ {
+					p.fill(50, 300+i*spacing, 240, 300+i*spacing+2)
+				}
+			},
+			systems: []System{{Top: 100, Bottom: 141, Staves: 1, StaffSpacing: spacing}},
+			staves:  1,
+			layout:  "solo",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newPage(600, 800)
+			test.draw(p)
+			got := Detect(p)
+			if got.Width != 600 || got.Height != 800 {
+				t.Errorf("got size %dx%d, want 600x800", got.Width, got.Height)
+			}
+			if got.Staves != test.staves || got.Layout != test.layout {
+				t.Errorf("got %d staves, layout %q, want %d, %q", got.Staves, got.Layout, test.staves, test.layout)
+			}
+			if len(got.Systems) != len(test.systems) {
+				t.Fatalf("got %d systems %+v, want %d", len(got.Systems), got.Systems, len(test.systems))
+			}
+			for i, want := range test.systems {
+				s := got.Systems[i]
+				if s.Top != want.Top || s.Bottom != want.Bottom || s.Staves != want.Staves || s.StaffSpacing != want.StaffSpacing {
+					t.Errorf("system %d: got %+v, want top %d, bottom %d, %d staves, spacing %g",
+						i, s, want.Top, want.Bottom, want.Staves, want.StaffSpacing)
+				}
+				if s.Left != 50 || s.Right != 550 {
+					t.Errorf("system %d: got left %d, right %d, want 50 and 550", i, s.Left, s.Right)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectInkDensity(t *testing.T) {
+	p := newPage(600, 400)
+	p.staff(100, 10)
+	if got := Detect(p).Systems[0].InkDensity; got != 0 {
+		t.Errorf("empty staff: got ink density %g, want 0", got)
+	}
+	// a note head between the second and third line
+	p.fill(300, 112, 310, 120)
+	if got := Detect(p).Systems[0].InkDensity; got <= 0 {
+		t.Errorf("staff with a note: got ink density %g, want more than 0", got)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	for staves, want := range map[int]string{0: "", 1: "solo", 2: "grand", 3: "score", 12: "score"} {
+		if got := Layout(staves); got != want {
+			t.Errorf("Layout(%d) = %q, want %q", staves, got, want)
+		}
+	}
+}